@@ -2,6 +2,7 @@ package caddyyaml
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -10,10 +11,10 @@ import (
 
 // adapt processes YAML configuration and converts it to Caddy JSON format.
 // Processing pipeline:
-// 1. Process includes (if present)
-// 2. Extract x- variables for templates
-// 3. Apply Go templates
-// 4. Remove extension fields recursively
+// 1. Resolve includes (if present) without templating
+// 2. Extract x- variables for templates from every file
+// 3. Apply Go templates to every file
+// 4. Merge the files, order routes by priority and remove extension fields
 // 5. Convert to JSON
 func adapt(body []byte, options map[string]any) ([]byte, []caddyconfig.Warning, error) {
 	filename, ok := options["filename"].(string)
@@ -26,28 +27,121 @@ func adapt(body []byte, options map[string]any) ([]byte, []caddyconfig.Warning,
 		env = os.Environ()
 	}
 
+	prov, ok := options[ProvenanceOptionName].(*Provenance)
+	if !ok || prov == nil {
+		prov = NewProvenance()
+	}
+	prov.positions = make(map[string]Position)
+
 	wc := newWarningsCollector(filename)
+	run := parseCache.startRun()
 
-	// Phase 1: Process includes
-	baseDir := filepath.Dir(filename)
-	body, err := processIncludes(body, baseDir, []string{filename})
+	// Phase 1: Resolve includes
+	s, err := loadSettings(body, filename, options)
+	if err != nil {
+		return nil, wc.warnings, err
+	}
+	tc := newTemplateContext(env, s, wc)
+
+	loader, err := newIncludeLoader(options, s, tc, wc)
+	if err != nil {
+		return nil, wc.warnings, err
+	}
+	sources, err := loader.processIncludes(body, filename, includeScope{}, []string{filepath.Clean(filename)})
 	if err != nil {
 		return nil, wc.warnings, err
 	}
 
 	// Phase 2: Extract x- variables for templates
-	vars, err := parseExtensionVars(body, env)
+	vars, locals, err := parseSourcesVars(sources, tc)
 	if err != nil {
 		return nil, wc.warnings, err
 	}
 
-	// Phase 3: Apply Go templates
-	body, err = applyTemplate(body, vars, env, wc)
+	// Phase 3 & 4: Apply Go templates and merge
+	config, err := mergeSources(sources, vars, locals, loader, prov)
 	if err != nil {
 		return nil, wc.warnings, err
 	}
 
-	// Phase 4 & 5: Remove extensions and convert to JSON
-	result, err := yamlToJSON(body)
-	return result, wc.warnings, err
+	// Phase 5: Remove extensions and convert to JSON
+	result, err := configToJSON(config)
+	if err != nil {
+		return nil, wc.warnings, err
+	}
+
+	parseCache.prune(run)
+	return result, wc.warnings, nil
+}
+
+// parseSourcesVars extracts the x- variables of every source into a single set of template values,
+// placing them under the namespace of the source. It also returns the x-local- variables of every source.
+func parseSourcesVars(sources []source, tc *templateContext) (map[string]any, []map[string]any, error) {
+	vars := make(map[string]any)
+	locals := make([]map[string]any, len(sources))
+	for i, src := range sources {
+		if !src.templated() {
+			continue
+		}
+		srcVars, srcLocals, err := parseCache.vars(src, tc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := mergeConfig(vars, namespaceVars(srcVars, src.scope.namespace)); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge extension fields of %s: %w", src.path, err)
+		}
+		locals[i] = srcLocals
+	}
+	return vars, locals, nil
+}
+
+// mergeSources applies templates to the sources, merges them into a single config
+// and orders its routes by priority, recording the positions of the merged values in prov.
+func mergeSources(sources []source, vars map[string]any, locals []map[string]any, loader *includeLoader, prov *Provenance) (map[string]any, error) {
+	config := make(map[string]any)
+	for i, src := range sources {
+		srcVars := sourceVars(vars, src, locals[i])
+		if err := mergeSource(config, src, newTagResolver(loader, srcVars, loader.tc), prov); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sortRoutes(config, "", prov); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// mergeSource applies templates to a single source, parses it and merges it into config,
+// recording the positions of the merged values in prov. File tags are resolved with r.
+func mergeSource(config map[string]any, src source, r *tagResolver, prov *Provenance) error {
+	body := src.body
+	if src.templated() {
+		var err error
+		if body, err = applyTemplate(src.path, src.body, r.vars, r.tc, r.tc.fileDelims(src.body)); err != nil {
+			return err
+		}
+	}
+
+	srcConfig, positions, err := parseCache.decode(src, body, r)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", src.path, err)
+	}
+	if src.format == formatCaddyfile {
+		locateCaddyfile(src, positions)
+	}
+
+	// Include directives and adapter settings were processed before templating
+	delete(srcConfig, "include")
+	delete(srcConfig, "adapter")
+
+	// Extension fields were extracted into template values, which may be namespaced or file-local
+	removeExtensions(srcConfig)
+
+	m := &merger{prov: prov, positions: positions}
+	if err := m.mergeMap(config, srcConfig, "", ""); err != nil {
+		return fmt.Errorf("failed to merge include %s: %w", src.path, err)
+	}
+
+	return nil
 }
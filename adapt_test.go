@@ -1,12 +1,69 @@
 package caddyyaml
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 )
 
+// caddyfileServerType sets up the server blocks parsed by the caddyfile adapter. It stands in for
+// the HTTP server type, whose dependencies cannot be initialized by the Go versions this repo is tested with,
+// and supports site blocks holding respond directives.
+type caddyfileServerType struct{}
+
+func (caddyfileServerType) Setup(blocks []caddyfile.ServerBlock, _ map[string]any) (*caddy.Config, []caddyconfig.Warning, error) {
+	var routes []any
+	for _, block := range blocks {
+		for _, segment := range block.Segments {
+			d := caddyfile.NewDispenser(segment)
+			d.Next()
+			if d.Val() != "respond" {
+				return nil, nil, d.Errf("unrecognized directive: %s", d.Val())
+			}
+			if !d.NextArg() {
+				return nil, nil, d.ArgErr()
+			}
+			routes = append(routes, map[string]any{
+				"match":  []any{map[string]any{"host": block.Keys}},
+				"handle": []any{map[string]any{"handler": "static_response", "body": d.Val()}},
+			})
+		}
+	}
+
+	servers := map[string]any{"srv0": map[string]any{"listen": []string{":443"}, "routes": routes}}
+	http, err := json.Marshal(map[string]any{"servers": servers})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &caddy.Config{AppsRaw: caddy.ModuleMap{"http": http}}, nil, nil
+}
+
+func init() {
+	caddyconfig.RegisterAdapter(caddyfileAdapterName, caddyfile.Adapter{ServerType: caddyfileServerType{}})
+}
+
 func TestApply(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -70,6 +127,139 @@ func TestApply(t *testing.T) {
 			jsonFile: "test.include-recursive.json",
 			env:      []string{"ENVIRONMENT=test"},
 		},
+		{
+			name:     "include with template conditionals",
+			yamlFile: "test.include-template.yaml",
+			jsonFile: "test.include-template.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "include with template conditionals in production",
+			yamlFile: "test.include-template.yaml",
+			jsonFile: "test.include-template.prod.json",
+			env:      []string{"ENVIRONMENT=production"},
+		},
+		{
+			name:     "include glob patterns",
+			yamlFile: "test.include-glob.yaml",
+			jsonFile: "test.include-glob.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "optional includes",
+			yamlFile: "test.include-optional.yaml",
+			jsonFile: "test.include-optional.json",
+			env:      []string{"ENVIRONMENT=test"},
+			expectedWarnings: []string{
+				"./testdata/test.include-optional.yaml:3 (include): optional include testdata/local-overrides.yaml not found, skipping",
+				"./testdata/test.include-optional.yaml:5 (include): optional include pattern ./overrides.d/*.yaml matched no files, skipping",
+			},
+		},
+		{
+			name:     "conditional includes",
+			yamlFile: "test.include-if.yaml",
+			jsonFile: "test.include-if.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "conditional includes in production",
+			yamlFile: "test.include-if.yaml",
+			jsonFile: "test.include-if.prod.json",
+			env:      []string{"ENVIRONMENT=production"},
+		},
+		{
+			name:     "templated include paths",
+			yamlFile: "test.include-paths.yaml",
+			jsonFile: "test.include-paths.json",
+			env:      []string{"ENVIRONMENT=test", "SHARED_DIR=./include-paths/shared"},
+		},
+		{
+			name:     "templated include paths in production",
+			yamlFile: "test.include-paths.yaml",
+			jsonFile: "test.include-paths.prod.json",
+			env:      []string{"ENVIRONMENT=production", "SHARED_DIR=./include-paths/shared"},
+		},
+		{
+			name:     "JSON and Caddyfile includes",
+			yamlFile: "test.include-formats.yaml",
+			jsonFile: "test.include-formats.json",
+			env:      []string{"ENVIRONMENT=test"},
+			expectedWarnings: []string{
+				"testdata/include-formats/blog.caddyfile:2: input is not formatted with 'caddy fmt'",
+			},
+		},
+		{
+			name:     "directory includes without formats",
+			yamlFile: "test.include-formats-default.yaml",
+			jsonFile: "test.include-formats-default.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "per-include variables",
+			yamlFile: "test.include-vars.yaml",
+			jsonFile: "test.include-vars.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "namespaced and local extension fields",
+			yamlFile: "test.include-as.yaml",
+			jsonFile: "test.include-as.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "environment functions without environment variables",
+			yamlFile: "test.env-functions.yaml",
+			jsonFile: "test.env-functions.json",
+			env:      []string{"ENVIRONMENT=test", "INVALID%=invalid_name"},
+		},
+		{
+			name:     "template delimiters and raw blocks",
+			yamlFile: "test.delims.yaml",
+			jsonFile: "test.delims.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "include tag merging mappings",
+			yamlFile: "test.include-tag-merge.yaml",
+			jsonFile: "test.include-tag-merge.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "merge tags",
+			yamlFile: "test.merge-tags.yaml",
+			jsonFile: "test.merge-tags.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "route priority",
+			yamlFile: "test.route-priority.yaml",
+			jsonFile: "test.route-priority.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "include directory filtering",
+			yamlFile: "test.include-confd.yaml",
+			jsonFile: "test.include-confd.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "include glob filtering",
+			yamlFile: "test.include-confd-glob.yaml",
+			jsonFile: "test.include-confd.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "include tag",
+			yamlFile: "test.include-tag.yaml",
+			jsonFile: "test.include-tag.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
+		{
+			name:     "file tags",
+			yamlFile: "test.file-tag.yaml",
+			jsonFile: "test.file-tag.json",
+			env:      []string{"ENVIRONMENT=test"},
+		},
 		{
 			name:     "placeholder corruption",
 			yamlFile: "test.placeholders.yaml",
@@ -124,6 +314,833 @@ func TestApply(t *testing.T) {
 	}
 }
 
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		yaml        string
+		options     map[string]any
+		expectedErr string
+	}{
+		{
+			name:        "glob without matches",
+			yaml:        "include:\n  - path: ./include-glob/none/*.yaml\n",
+			expectedErr: "include pattern ./include-glob/none/*.yaml matched no files",
+		},
+		{
+			name:        "missing required include",
+			yaml:        "include:\n  - path: ./missing.yaml\n",
+			expectedErr: "failed to stat path testdata/missing.yaml: stat testdata/missing.yaml: no such file or directory",
+		},
+		{
+			name:        "non-boolean required",
+			yaml:        "include:\n  - path: ./missing.yaml\n    required: \"no\"\n",
+			expectedErr: "include[0].required must be a boolean",
+		},
+		{
+			name:        "non-string include condition",
+			yaml:        "include:\n  - path: ./missing.yaml\n    if: true\n",
+			expectedErr: "include[0].if must be a non-empty string",
+		},
+		{
+			name:        "non-boolean include condition",
+			yaml:        "include:\n  - path: ./missing.yaml\n    if: '#{ $ENVIRONMENT }'\n",
+			expectedErr: "./testdata/inline.yaml:2: include condition \"#{ $ENVIRONMENT }\" must render to true or false, got \"test\"",
+		},
+		{
+			name:        "non-map include vars",
+			yaml:        "include:\n  - path: ./missing.yaml\n    vars: [domain]\n",
+			expectedErr: "include[0].vars must be a map",
+		},
+		{
+			name:        "invalid include alias",
+			yaml:        "include:\n  - path: ./missing.yaml\n    as: blog.example\n",
+			expectedErr: "include[0].as must be a name of letters, digits, hyphens and underscores",
+		},
+		{
+			name:        "template execution error",
+			yaml:        "x-port: 80\napps:\n  http:\n    servers:\n      main:\n        listen: [\":#{ index .port 3 }\"]\n",
+			expectedErr: "./testdata/inline.yaml:6:23: at <index .port 3>: error calling index: can't index item of type int\n  4 |     servers:\n  5 |       main:\n> 6 |         listen: [\":#{ index .port 3 }\"]\n    |                       ^",
+		},
+		{
+			name:        "template parse error in extension field",
+			yaml:        "x-a: 1\napps: {}\nx-b: #{ if }\n",
+			expectedErr: "./testdata/inline.yaml:3: missing value for if\n  1 | x-a: 1\n  2 | \n> 3 | x-b: #{ if }",
+		},
+		{
+			name:        "required environment variable",
+			yaml:        "apps:\n  http:\n    servers:\n      main:\n        listen: [\":#{ required \"PORT\" }\"]\n",
+			expectedErr: "./testdata/inline.yaml:5:23: at <required \"PORT\">: error calling required: environment variable PORT is required\n  3 |     servers:\n  4 |       main:\n> 5 |         listen: [\":#{ required \"PORT\" }\"]\n    |                       ^",
+		},
+		{
+			name:        "strict missing variable",
+			yaml:        "adapter:\n  strict: true\nx-tls-email: admin@example.com\napps:\n  tls:\n    email: #{ .tls_emial }\n",
+			expectedErr: "./testdata/inline.yaml:6:15: at <.tls_emial>: missing or null value for key \"tls_emial\"\n  4 | apps:\n  5 |   tls:\n> 6 |     email: #{ .tls_emial }\n    |               ^",
+		},
+		{
+			name:        "strict null variable",
+			yaml:        "x-domain:\napps:\n  http:\n    servers:\n      main:\n        listen: [\":80\"]\n        routes:\n          - match: [{host: [\"#{ .domain }\"]}]\n",
+			options:     map[string]any{StrictOptionName: true},
+			expectedErr: "./testdata/inline.yaml:8:33: at <.domain>: missing or null value for key \"domain\"\n  6 |         listen: [\":80\"]\n  7 |         routes:\n> 8 |           - match: [{host: [\"#{ .domain }\"]}]\n    |                                 ^",
+		},
+		{
+			name:        "strict unset environment variable",
+			yaml:        "apps:\n  http:\n    servers:\n      main:\n        listen: [\":#{ env \"PORT\" }\"]\n",
+			options:     map[string]any{StrictOptionName: true},
+			expectedErr: "./testdata/inline.yaml:5:23: at <env \"PORT\">: error calling env: environment variable PORT is not set, pass a default as in env \"PORT\" \"default\"\n  3 |     servers:\n  4 |       main:\n> 5 |         listen: [\":#{ env \"PORT\" }\"]\n    |                       ^",
+		},
+		{
+			name:        "strict no value output",
+			yaml:        "x-ports: {}\napps:\n  http:\n    servers:\n      main:\n        listen: [\":#{ index .ports \"http\" }\"]\n",
+			options:     map[string]any{StrictOptionName: true},
+			expectedErr: "./testdata/inline.yaml:6: template rendered <no value> for a missing or null value\n  4 |     servers:\n  5 |       main:\n> 6 |         listen: [\":#{ index .ports \"http\" }\"]",
+		},
+		{
+			name:        "unterminated raw block",
+			yaml:        "apps:\n  http:\n    servers:\n      main:\n        listen: [\"#{ raw }:#{ .port }\"]\n",
+			expectedErr: "./testdata/inline.yaml:5: raw block is missing #{ endraw }\n  3 |     servers:\n  4 |       main:\n> 5 |         listen: [\"#{ raw }:#{ .port }\"]",
+		},
+		{
+			name:        "delimiters option",
+			yaml:        "x-port: 80\napps:\n  http:\n    servers:\n      main:\n        listen: [\":[[ .port ]]\"]\n        routes:\n          - handle:\n              - handler: static_response\n                body: \"[[ .missing.host ]]\"\n",
+			options:     map[string]any{DelimitersOptionName: []string{"[[", "]]"}},
+			expectedErr: "./testdata/inline.yaml:10:35: at <.missing.host>: nil pointer evaluating interface {}.host\n   8 |           - handle:\n   9 |               - handler: static_response\n> 10 |                 body: \"[[ .missing.host ]]\"\n     |                                   ^",
+		},
+		{
+			name:        "invalid delimiters",
+			yaml:        "adapter:\n  delimiters: [\"#{\"]\n",
+			expectedErr: "invalid value for adapter.delimiters: [#{]",
+		},
+		{
+			name:        "non-deterministic function in reproducible mode",
+			yaml:        "adapter:\n  reproducible: true\napps:\n  http:\n    servers:\n      main:\n        routes:\n          - '@id': #{ uuidv4 }\n",
+			expectedErr: "./testdata/inline.yaml:8:23: at <uuidv4>: error calling uuidv4: function uuidv4 is not available in reproducible mode\n  6 |       main:\n  7 |         routes:\n> 8 |           - '@id': #{ uuidv4 }\n    |                       ^",
+		},
+		{
+			name:        "denied function",
+			yaml:        "apps:\n  http:\n    servers:\n      main:\n        listen: [\":#{ env \"PORT\" \"80\" }\"]\n",
+			options:     map[string]any{DenyFuncsOptionName: []string{"env", "expandenv"}},
+			expectedErr: "./testdata/inline.yaml:5:23: at <env \"PORT\" \"80\">: error calling env: function env is denied\n  3 |     servers:\n  4 |       main:\n> 5 |         listen: [\":#{ env \"PORT\" \"80\" }\"]\n    |                       ^",
+		},
+		{
+			name:        "environment variables without env function",
+			yaml:        "apps:\n  http:\n    servers:\n      main:\n        listen: [\":80\"]\n        routes:\n          - '@id': #{ $ENVIRONMENT }\n",
+			options:     map[string]any{DenyFuncsOptionName: []string{"env"}},
+			expectedErr: "./testdata/inline.yaml:7: undefined variable \"$ENVIRONMENT\"\n  5 |         listen: [\":80\"]\n  6 |         routes:\n> 7 |           - '@id': #{ $ENVIRONMENT }",
+		},
+		{
+			name:        "function not in allowed functions",
+			yaml:        "x-domain: example.com\napps:\n  http:\n    servers:\n      main:\n        routes:\n          - match: [{host: [\"#{ .domain | lower }\", \"#{ .domain | upper }\"]}]\n",
+			options:     map[string]any{AllowFuncsOptionName: []string{"lower"}},
+			expectedErr: "./testdata/inline.yaml:7:67: at <upper>: error calling upper: function upper is not in the allowed functions\n  5 |       main:\n  6 |         routes:\n> 7 |           - match: [{host: [\"#{ .domain | lower }\", \"#{ .domain | upper }\"]}]\n    |                                                                   ^",
+		},
+		{
+			name:        "unknown denied function",
+			yaml:        "adapter:\n  deny_funcs: [nwo]\n",
+			expectedErr: "invalid value for adapter.deny_funcs: [nwo]",
+		},
+		{
+			name:        "remote include without checksum",
+			yaml:        "include:\n  - https://example.com/routes.yaml\n",
+			expectedErr: "remote include https://example.com/routes.yaml requires a sha256 checksum",
+		},
+		{
+			name:        "scalar conflict without override",
+			yaml:        "include:\n  - path: ./include-base.yaml\napps:\n  http:\n    servers:\n      srv0:\n        listen: \":80\"\n",
+			expectedErr: "failed to merge include testdata/include-base.yaml: conflict at key \"listen\": cannot merge string (./testdata/inline.yaml:7:9) with []interface {} (testdata/include-base.yaml:7:9) (use !override to replace it)",
+		},
+		{
+			name:        "non-numeric route priority",
+			yaml:        "apps:\n  http:\n    servers:\n      srv0:\n        routes:\n          - x-priority: high\n",
+			expectedErr: "/apps/http/servers/srv0/routes/0/x-priority must be a number, got string (./testdata/inline.yaml:6:13)",
+		},
+		{
+			name:        "unknown include format",
+			yaml:        "include:\n  - path: ./include-formats\n    formats: [yaml, toml]\n",
+			expectedErr: "include[0].formats: unknown format \"toml\", must be yaml, json or caddyfile",
+		},
+		{
+			name:        "include tag conflict",
+			yaml:        "logging: !include ./include-tag-conflict/*.yaml\n",
+			expectedErr: "failed to parse ./testdata/inline.yaml: ./testdata/inline.yaml:1: !include ./include-tag-conflict/*.yaml: conflict at key \"level\": cannot merge string (testdata/include-tag-conflict/a.yaml:3:5) with string (testdata/include-tag-conflict/b.yaml:3:5) (use !override to replace it)",
+		},
+		{
+			name:        "invalid include sort",
+			yaml:        "include:\n  - path: ./include-confd\n    sort: random\n",
+			expectedErr: "include[0].sort must be \"lexical\" or \"natural\"",
+		},
+		{
+			name:        "circular include tag",
+			yaml:        "apps: !include ./inline.yaml\n",
+			expectedErr: "failed to parse ./testdata/inline.yaml: ./testdata/inline.yaml:1: !include ./inline.yaml: circular include detected: testdata/inline.yaml",
+		},
+		{
+			name:        "include outside include root setting",
+			yaml:        "adapter:\n  include_root: ./include-dir\ninclude:\n  - ../testdata/include-base.yaml\n",
+			expectedErr: "failed to stat path testdata/include-base.yaml: include testdata/include-base.yaml is outside the include root testdata/include-dir",
+		},
+		{
+			name:        "include outside include root option",
+			yaml:        "include:\n  - /etc/passwd\n",
+			options:     map[string]any{IncludeRootOptionName: "testdata"},
+			expectedErr: "failed to stat path /etc/passwd: include /etc/passwd is outside the include root testdata",
+		},
+		{
+			name:        "checksum on a local include",
+			yaml:        "include:\n  - path: ./include-base.yaml\n    sha256: \"0000000000000000000000000000000000000000000000000000000000000000\"\n",
+			expectedErr: "./testdata/inline.yaml:2: include[0].sha256 requires a remote include, got ./include-base.yaml",
+		},
+		{
+			name:        "glob outside include root",
+			yaml:        "include:\n  - ./include-glob/**/*.yaml\n",
+			options:     map[string]any{IncludeRootOptionName: "testdata/include-dir"},
+			expectedErr: "failed to expand pattern ./include-glob/**/*.yaml: include testdata/include-glob is outside the include root testdata/include-dir",
+		},
+		{
+			name:        "include tag glob outside include root",
+			yaml:        "logging: !include /etc/*\n",
+			options:     map[string]any{IncludeRootOptionName: "testdata"},
+			expectedErr: "failed to parse ./testdata/inline.yaml: ./testdata/inline.yaml:1: !include /etc/*: failed to expand pattern /etc/*: include /etc is outside the include root testdata",
+		},
+		{
+			name:        "include root option takes precedence",
+			yaml:        "adapter:\n  include_root: /\ninclude:\n  - ./include-base.yaml\n",
+			options:     map[string]any{IncludeRootOptionName: "testdata/include-dir"},
+			expectedErr: "failed to stat path testdata/include-base.yaml: include testdata/include-base.yaml is outside the include root testdata/include-dir",
+		},
+		{
+			name:        "include depth limit",
+			yaml:        "adapter:\n  max_include_depth: 1\ninclude:\n  - ./test.include.yaml\n",
+			expectedErr: "include depth limit of 1 exceeded by testdata/include-base.yaml",
+		},
+		{
+			name:        "include file limit",
+			yaml:        "include:\n  - ./include-dir\n",
+			options:     map[string]any{MaxIncludeFilesOptionName: 2},
+			expectedErr: "failed to read include file testdata/include-dir/server.yaml: include file limit of 2 exceeded by testdata/include-dir/server.yaml",
+		},
+		{
+			name:        "include size limit",
+			yaml:        "adapter:\n  max_include_size: 10\ninclude:\n  - ./include-base.yaml\n",
+			expectedErr: "failed to read include file testdata/include-base.yaml: include size limit of 10 bytes exceeded by testdata/include-base.yaml",
+		},
+		{
+			name:        "unknown adapter setting",
+			yaml:        "adapter:\n  include_rot: ./\n",
+			expectedErr: "unknown adapter setting \"include_rot\"",
+		},
+		{
+			name:        "invalid exclude pattern",
+			yaml:        "include:\n  - path: ./include-glob/sites\n    exclude: [\"[\"]\n",
+			expectedErr: "include[0].exclude: invalid pattern \"[\": syntax error in pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]any{
+				"filename":    "./testdata/inline.yaml",
+				envOptionName: []string{"ENVIRONMENT=test"},
+			}
+			maps.Copy(options, tt.options)
+			_, _, err := Adapter{}.Adapt([]byte(tt.yaml), options)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if err.Error() != tt.expectedErr {
+				t.Fatalf("expected error %q, got %q", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRemoteInclude(t *testing.T) {
+	remote := []byte("apps:\n  http:\n    servers:\n      srv0:\n        listen: [\":8443\"]\n")
+	sum := sha256.Sum256(remote)
+	checksum := hex.EncodeToString(sum[:])
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/listen.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(remote)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	adaptRemote := func(path, checksum string, required ...bool) ([]byte, error) {
+		body := fmt.Sprintf("include:\n  - path: %s\n    sha256: \"%s\"\n", path, checksum)
+		if len(required) > 0 {
+			body += fmt.Sprintf("    required: %t\n", required[0])
+		}
+		adapted, _, err := Adapter{}.Adapt([]byte(body), map[string]any{
+			"filename":                "./testdata/inline.yaml",
+			envOptionName:             []string{},
+			IncludeCacheDirOptionName: cacheDir,
+			httpClientOptionName:      server.Client(),
+		})
+		return adapted, err
+	}
+
+	expected := `{"apps":{"http":{"servers":{"srv0":{"listen":[":8443"]}}}}}`
+	adapted, err := adaptRemote(server.URL+"/listen.yaml", checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(adapted) != expected {
+		t.Fatalf("expected %s, got %s", expected, adapted)
+	}
+
+	_, err = adaptRemote(server.URL+"/listen.yaml", strings.Repeat("0", 64))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	// A checksum mismatch is fatal even for optional includes, unlike a missing file
+	_, err = adaptRemote(server.URL+"/listen.yaml", strings.Repeat("0", 64), false)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch for optional include, got %v", err)
+	}
+	if _, err = adaptRemote(server.URL+"/missing.yaml", strings.Repeat("0", 64), false); err != nil {
+		t.Fatalf("expected missing optional include to be skipped, got %v", err)
+	}
+
+	// Once fetched, the include is served from the cache
+	server.Close()
+	adapted, err = adaptRemote(server.URL+"/listen.yaml", checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(adapted) != expected {
+		t.Fatalf("expected %s from cache, got %s", expected, adapted)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	tests := []struct {
+		yamlFile string
+		pointer  string
+		expected string
+	}{
+		{
+			yamlFile: "test.include.yaml",
+			pointer:  "/apps/http/servers/srv0/logs/default_logger_name",
+			expected: "./testdata/test.include.yaml:10:11",
+		},
+		{
+			yamlFile: "test.include.yaml",
+			pointer:  "/apps/http/servers/srv0/listen/0",
+			expected: "testdata/include-base.yaml:8:13",
+		},
+		{
+			yamlFile: "test.include.yaml",
+			pointer:  "/apps/http/servers/srv0/routes/0/handle/0/handler",
+			expected: "testdata/include-routes.yaml:13:17",
+		},
+		{
+			yamlFile: "test.include-tag.yaml",
+			pointer:  "/apps/http/servers/srv0/routes/0/handle/1/upstreams",
+			expected: "testdata/include-tag/handlers/b-proxy.yaml:2:3",
+		},
+		{
+			yamlFile: "test.include-tag-merge.yaml",
+			pointer:  "/apps/http/servers/main/listen/0",
+			expected: "testdata/include-tag-merge/a-main.yaml:3:14",
+		},
+		{
+			yamlFile: "test.include-tag-merge.yaml",
+			pointer:  "/apps/http/servers/main/routes/1/handle/0/body",
+			expected: "testdata/include-tag-merge/b-admin.yaml:7:13",
+		},
+		{
+			yamlFile: "test.include-tag-merge.yaml",
+			pointer:  "/apps/http/servers/main/logs/default_logger_name",
+			expected: "testdata/include-tag-merge/b-admin.yaml:9:7",
+		},
+		{
+			yamlFile: "test.route-priority.yaml",
+			pointer:  "/apps/http/servers/srv0/routes/0/match",
+			expected: "testdata/route-priority/20-api.yaml:7:13",
+		},
+		{
+			yamlFile: "test.route-priority.yaml",
+			pointer:  "/apps/http/servers/srv0/routes/2",
+			expected: "testdata/route-priority/00-defaults.yaml:6:13",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pointer, func(t *testing.T) {
+			b, err := os.ReadFile("./testdata/" + tt.yamlFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prov := NewProvenance()
+			_, _, err = Adapter{}.Adapt(b, map[string]any{
+				"filename":           "./testdata/" + tt.yamlFile,
+				envOptionName:        []string{"ENVIRONMENT=test"},
+				ProvenanceOptionName: prov,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pos, ok := prov.Lookup(tt.pointer)
+			if !ok {
+				t.Fatalf("no position recorded for %s", tt.pointer)
+			}
+			if pos.String() != tt.expected {
+				t.Fatalf("expected position %s, got %s", tt.expected, pos)
+			}
+		})
+	}
+
+	t.Run("stripped route priorities", func(t *testing.T) {
+		b, err := os.ReadFile("./testdata/test.route-priority.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		prov := NewProvenance()
+		_, _, err = Adapter{}.Adapt(b, map[string]any{
+			"filename":           "./testdata/test.route-priority.yaml",
+			ProvenanceOptionName: prov,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range prov.Paths() {
+			if strings.HasSuffix(p, "/"+routePriorityKey) {
+				t.Fatalf("expected no position for the removed %s", p)
+			}
+		}
+	})
+
+	t.Run("nil provenance", func(t *testing.T) {
+		var prov *Provenance
+		_, _, err := Adapter{}.Adapt([]byte("logging: {}\n"), map[string]any{
+			"filename":           "./testdata/inline.yaml",
+			ProvenanceOptionName: prov,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestIncludeSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/target.yaml", []byte("logging: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir+"/target.yaml", dir+"/link.yaml"); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	body := []byte("include:\n  - ./link.yaml\n")
+	options := map[string]any{
+		"filename":    dir + "/caddy.yaml",
+		envOptionName: []string{},
+	}
+	if _, _, err := (Adapter{}).Adapt(body, options); err != nil {
+		t.Fatal(err)
+	}
+
+	options[FollowSymlinksOptionName] = false
+	_, _, err := Adapter{}.Adapt(body, options)
+	link := dir + "/link.yaml"
+	expectedErr := "failed to stat path " + link + ": include " + link + " is a symlink, which is not allowed"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+
+	// A symlinked directory under the include root may not lead out of it
+	for _, name := range []string{"root", "outside"} {
+		if err := os.Mkdir(dir+"/"+name, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(dir+"/outside/s.yaml", []byte("logging: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir+"/outside", dir+"/root/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	linked := dir + "/root/link/s.yaml"
+	tests := []struct {
+		body           string
+		followSymlinks bool
+		expectedErr    string
+	}{
+		{
+			body:           "include:\n  - ./link/s.yaml\n",
+			followSymlinks: true,
+			expectedErr:    "failed to stat path " + linked + ": include " + linked + " resolves to " + dir + "/outside/s.yaml outside the include root " + dir + "/root",
+		},
+		{
+			body:        "include:\n  - ./link/s.yaml\n",
+			expectedErr: "failed to stat path " + linked + ": include " + linked + " is in the symlinked directory " + dir + "/root/link, which is not allowed",
+		},
+		{
+			body:           "include:\n  - ./*\n",
+			followSymlinks: true,
+			expectedErr:    "include pattern ./* matched no files",
+		},
+		{
+			body:        "logging: !file ./link/s.yaml\n",
+			expectedErr: "failed to parse " + dir + "/root/caddy.yaml: " + dir + "/root/caddy.yaml:1: !file ./link/s.yaml: include " + linked + " is in the symlinked directory " + dir + "/root/link, which is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := Adapter{}.Adapt([]byte(tt.body), map[string]any{
+			"filename":               dir + "/root/caddy.yaml",
+			envOptionName:            []string{},
+			IncludeRootOptionName:    dir + "/root",
+			FollowSymlinksOptionName: tt.followSymlinks,
+		})
+		if err == nil || err.Error() != tt.expectedErr {
+			t.Fatalf("expected error %q, got %v", tt.expectedErr, err)
+		}
+	}
+}
+
+func TestIncludeSizeLimit(t *testing.T) {
+	if _, err := os.Stat("/dev/zero"); err != nil {
+		t.Skip("no /dev/zero:", err)
+	}
+
+	// Files without a size are read no further than the limit
+	_, _, err := Adapter{}.Adapt([]byte("logging: !file /dev/zero\n"), map[string]any{
+		"filename":               "./testdata/inline.yaml",
+		envOptionName:            []string{},
+		MaxIncludeSizeOptionName: 1024,
+	})
+	expectedErr := "include size limit of 1024 bytes exceeded by /dev/zero"
+	if err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) {
+		if err := os.WriteFile(dir+"/site.yaml", []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := []byte("x-level: INFO\ninclude:\n  - ./site.yaml\nlogging:\n  logs:\n    default:\n      level: #{ .level }\n")
+	options := map[string]any{
+		"filename":    dir + "/caddy.yaml",
+		envOptionName: []string{},
+	}
+	adapt := func(expected string) {
+		t.Helper()
+		adapted, _, err := Adapter{}.Adapt(body, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(adapted) != expected {
+			t.Fatalf("expected %s, got %s", expected, adapted)
+		}
+	}
+
+	write("logging:\n  logs:\n    default:\n      include: [http.log.access]\n")
+	// Adapting twice serves the second run from the cache, which must not be modified by merging
+	adapt(`{"logging":{"logs":{"default":{"include":["http.log.access"],"level":"INFO"}}}}`)
+	adapt(`{"logging":{"logs":{"default":{"include":["http.log.access"],"level":"INFO"}}}}`)
+
+	parseCache.mu.Lock()
+	entry := parseCache.entries[dir+"/site.yaml"]
+	parseCache.mu.Unlock()
+	if entry == nil || entry.config == nil {
+		t.Fatal("expected the parsed include to be cached")
+	}
+
+	// A changed file of the same size is parsed again
+	write("logging:\n  logs:\n    default:\n      include: [http.log.errors]\n")
+	adapt(`{"logging":{"logs":{"default":{"include":["http.log.errors"],"level":"INFO"}}}}`)
+
+	// Changed template values are applied to the cached source
+	body = []byte(strings.Replace(string(body), "INFO", "DEBUG", 1))
+	adapt(`{"logging":{"logs":{"default":{"include":["http.log.errors"],"level":"DEBUG"}}}}`)
+
+	// Files no longer included are dropped from the cache
+	body = []byte("logging:\n  logs:\n    default:\n      level: INFO\n")
+	adapt(`{"logging":{"logs":{"default":{"level":"INFO"}}}}`)
+	parseCache.mu.Lock()
+	_, ok := parseCache.entries[dir+"/site.yaml"]
+	parseCache.mu.Unlock()
+	if ok {
+		t.Fatal("expected the include to be removed from the cache")
+	}
+}
+
+func TestIncludeHomeDir(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(home+"/logging.yaml", []byte("logging: {logs: {default: {level: INFO}}}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	adapted, warnings, err := Adapter{}.Adapt([]byte("include:\n  - ~/logging.yaml\n  - path: $UNSET/missing.yaml\n    required: false\n"), map[string]any{
+		"filename":    "./testdata/caddy.yaml",
+		envOptionName: []string{"HOME=" + home},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"logging":{"logs":{"default":{"level":"INFO"}}}}`
+	if string(adapted) != expected {
+		t.Fatalf("expected %s, got %s", expected, adapted)
+	}
+
+	expectedWarnings := []string{
+		"./testdata/caddy.yaml:3 (include): environment variable UNSET is not set, substituting an empty string",
+		"./testdata/caddy.yaml:3 (include): optional include /missing.yaml not found, skipping",
+	}
+	if len(warnings) != len(expectedWarnings) {
+		t.Fatalf("expected warnings %v, got %v", expectedWarnings, warnings)
+	}
+	for i, w := range warnings {
+		if w.String() != expectedWarnings[i] {
+			t.Fatalf("expected warning %q, got %q", expectedWarnings[i], w)
+		}
+	}
+
+	// Without the env function, ~ does not fall back to the home directory of the process
+	_, _, err = Adapter{}.Adapt([]byte("include:\n  - ~/logging.yaml\n"), map[string]any{
+		"filename":          "./testdata/caddy.yaml",
+		envOptionName:       []string{"HOME=" + home},
+		DenyFuncsOptionName: []string{"env"},
+	})
+	expectedErr := "./testdata/caddy.yaml:2: include path \"~/logging.yaml\": HOME is not available without the env function"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+}
+
+func TestGitInclude(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "--quiet", "--initial-branch=main")
+	write("sites/blog.yaml", "include:\n  - ../shared/logging.yaml\napps:\n  http:\n    servers:\n      main:\n        listen: [\":8080\"]\n")
+	write("shared/logging.yaml", "logging:\n  logs:\n    default:\n      level: INFO\n")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1.0.0")
+	write("sites/blog.yaml", "apps:\n  http:\n    servers:\n      main:\n        listen: [\":9090\"]\n")
+	git("commit", "--quiet", "-am", "v2")
+	// Changes to the working tree are not included
+	write("sites/blog.yaml", "apps:\n  http:\n    servers:\n      main:\n        listen: [\":7070\"]\n")
+
+	body := []byte("include:\n  - git: " + repo + "\n    ref: ${CONFIG_REF}\n    path: sites/*.yaml\n")
+	tests := []struct {
+		ref         string
+		expected    string
+		expectedErr string
+	}{
+		{
+			ref:      "v1.0.0",
+			expected: `{"apps":{"http":{"servers":{"main":{"listen":[":8080"]}}}},"logging":{"logs":{"default":{"level":"INFO"}}}}`,
+		},
+		{
+			ref:      "main",
+			expected: `{"apps":{"http":{"servers":{"main":{"listen":[":9090"]}}}}}`,
+		},
+		{
+			ref:         "v3.0.0",
+			expectedErr: "./testdata/caddy.yaml:2: failed to resolve ref v3.0.0 in git repository " + repo + ": git rev-parse: fatal: Needed a single revision",
+		},
+		{
+			ref:         "--git-dir=/nonexistent",
+			expectedErr: "./testdata/caddy.yaml:2: include ref \"--git-dir=/nonexistent\" must be a branch, tag or commit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			adapted, _, err := Adapter{}.Adapt(body, map[string]any{
+				"filename":    "./testdata/caddy.yaml",
+				envOptionName: []string{"CONFIG_REF=" + tt.ref},
+			})
+			if tt.expectedErr != "" {
+				if err == nil || err.Error() != tt.expectedErr {
+					t.Fatalf("expected error %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(adapted) != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, adapted)
+			}
+		})
+	}
+
+	fsys, err := newGitFS(repo, "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "sites/blog.yaml", "shared/logging.yaml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveInclude(t *testing.T) {
+	files := []struct{ name, content string }{
+		{"edge/sites/api.yaml", "apps:\n  http:\n    servers:\n      main:\n        listen: [\":9000\"]\n"},
+		{"edge/sites/blog.yaml", "include:\n  - ../shared/logging.yaml\napps:\n  http:\n    servers:\n      main:\n        listen: [\":8080\"]\n"},
+		{"edge/sites/README.md", "not a config file\n"},
+		{"edge/shared/logging.yaml", "logging:\n  logs:\n    default:\n      level: INFO\n"},
+	}
+
+	dir := t.TempDir()
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	zipFile, err := os.Create(filepath.Join(dir, "edge.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zipFile)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []io.Closer{tw, gz, zw, zipFile} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "edge.tar.gz"), tgz.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "tar.gz subdirectory",
+			yaml: "include:\n  - path: ./edge.tar.gz\n    subpath: edge/sites\n",
+		},
+		{
+			name: "zip glob",
+			yaml: "include:\n  - path: ./edge.zip\n    subpath: edge/sites/*.yaml\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapted, _, err := Adapter{}.Adapt([]byte(tt.yaml), map[string]any{
+				"filename":    filepath.Join(dir, "caddy.yaml"),
+				envOptionName: []string{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := `{"apps":{"http":{"servers":{"main":{"listen":[":9000",":8080"]}}}},"logging":{"logs":{"default":{"level":"INFO"}}}}`
+			if string(adapted) != expected {
+				t.Fatalf("expected %s, got %s", expected, adapted)
+			}
+		})
+	}
+}
+
+var registerTestFS sync.Once
+
+func TestIncludeFS(t *testing.T) {
+	base := fstest.MapFS{
+		"base/logging.yaml":     {Data: []byte("include:\n  - ./levels/*.yaml\nlogging:\n  logs:\n    default:\n      writer: {output: stderr}\n")},
+		"base/levels/info.yaml": {Data: []byte("logging:\n  logs:\n    default:\n      level: INFO\n")},
+	}
+	registerTestFS.Do(func() {
+		RegisterFS("testfs", base)
+	})
+
+	tests := []struct {
+		name     string
+		yaml     string
+		fsys     fs.FS
+		expected string
+	}{
+		{
+			name:     "registered filesystem",
+			yaml:     "include:\n  - testfs://base/logging.yaml\n",
+			expected: `{"logging":{"logs":{"default":{"level":"INFO","writer":{"output":"stderr"}}}}}`,
+		},
+		{
+			name:     "filesystem option",
+			yaml:     "include:\n  - ./base/logging.yaml\n",
+			fsys:     base,
+			expected: `{"logging":{"logs":{"default":{"level":"INFO","writer":{"output":"stderr"}}}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]any{
+				"filename":    "caddy.yaml",
+				envOptionName: []string{},
+			}
+			if tt.fsys != nil {
+				options[FSOptionName] = tt.fsys
+			}
+			adapted, _, err := Adapter{}.Adapt([]byte(tt.yaml), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(adapted) != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, adapted)
+			}
+		})
+	}
+
+	_, _, err := Adapter{}.Adapt([]byte("include:\n  - ../outside.yaml\n"), map[string]any{
+		"filename":    "caddy.yaml",
+		envOptionName: []string{},
+		FSOptionName:  base,
+	})
+	expectedErr := "failed to stat path ../outside.yaml: invalid path ../outside.yaml: paths cannot leave the filesystem root"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error %q, got %v", expectedErr, err)
+	}
+}
+
 func jsonToObj(b []byte) (obj map[string]any) {
 	if err := json.Unmarshal(b, &obj); err != nil {
 		panic(err)
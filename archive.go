@@ -0,0 +1,213 @@
+package caddyyaml
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveSchemePrefix prefixes the scheme of include paths read from an archive.
+// It is followed by the abbreviated sha256 checksum of the archive, as in archive+0123456789ab://sites/blog.yaml.
+const archiveSchemePrefix = "archive+"
+
+// maxArchiveSize limits the extracted size of an included archive.
+const maxArchiveSize = 64 << 20
+
+// isArchive reports whether the include path refers to a tar, tar.gz or zip archive.
+func isArchive(p string) bool {
+	name := strings.ToLower(p)
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIncludeArchiveOptions parses the subpath field of an include entry, selecting files within an archive.
+func parseIncludeArchiveOptions(configMap map[string]any, index int, inc *includeConfig) error {
+	value, exists := configMap["subpath"]
+	if !exists {
+		return nil
+	}
+
+	subpath, ok := value.(string)
+	if !ok || subpath == "" {
+		return fmt.Errorf("include[%d].subpath must be a path within the archive", index)
+	}
+	if err := validateGlob(subpath); err != nil {
+		return fmt.Errorf("include[%d].subpath: %w", index, err)
+	}
+	inc.Subpath = subpath
+	return nil
+}
+
+// processIncludeArchive includes the files of the archive at p as a directory.
+// The subpath of the include selects a directory, file or glob pattern within the archive.
+func (l *includeLoader) processIncludeArchive(p, from string, inc includeConfig, included []string) ([]source, error) {
+	content, err := l.readFile(p)
+	if errors.Is(err, fs.ErrNotExist) && !inc.Required {
+		l.wc.AddFile(from, inc.line, "include", fmt.Sprintf("optional include %s not found, skipping", p))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", p, err)
+	}
+
+	scheme, err := l.mountArchive(p, content)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveRoot := scheme + "://."
+	if hasGlobMeta(inc.Subpath) {
+		return l.processIncludeGlob(inc.Subpath, archiveRoot, from, inc, included)
+	}
+
+	root := joinIncludePath(archiveRoot, strings.TrimLeft(inc.Subpath, "/"))
+	info, err := l.stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat path %s in archive %s: %w", inc.Subpath, p, err)
+	}
+	if info.IsDir() {
+		return l.processIncludeDir(root, 1, inc, included)
+	}
+	return l.processIncludeSingleFile(root, inc.scope, included)
+}
+
+// mountArchive mounts the files of an archive, returning the scheme include paths in it are prefixed with.
+func (l *includeLoader) mountArchive(p string, content []byte) (string, error) {
+	scheme := archiveSchemePrefix + sha256Hex(content)[:12]
+	if _, ok := l.mounts[scheme]; ok {
+		return scheme, nil
+	}
+
+	var fsys *memFS
+	var err error
+	if strings.HasSuffix(strings.ToLower(p), ".zip") {
+		fsys, err = newZipFS(content)
+	} else {
+		fsys, err = newTarFS(p, content)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to extract archive %s: %w", p, err)
+	}
+
+	if l.mounts == nil {
+		l.mounts = make(map[string]*memFS)
+	}
+	l.mounts[scheme] = fsys
+	return scheme, nil
+}
+
+// newTarFS extracts a tar archive, gzip compressed if p ends in .gz or .tgz, into a filesystem.
+func newTarFS(p string, content []byte) (*memFS, error) {
+	var r io.Reader = bytes.NewReader(content)
+	if ext := strings.ToLower(filepath.Ext(p)); ext == ".gz" || ext == ".tgz" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	fsys := newMemFS()
+	tr := tar.NewReader(r)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if total, err = addTarEntry(fsys, tr, hdr, total); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// addTarEntry adds the current entry of tr, with header hdr, to fsys. It returns the total extracted size,
+// given the size total extracted before the entry.
+func addTarEntry(fsys *memFS, tr *tar.Reader, hdr *tar.Header, total int64) (int64, error) {
+	name, ok := archiveEntryName(hdr.Name)
+	if !ok {
+		return total, nil
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fsys.addDir(name)
+	case tar.TypeReg:
+		total += hdr.Size
+		if total > maxArchiveSize {
+			return 0, fmt.Errorf("extracted size exceeds %d bytes", maxArchiveSize)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return 0, err
+		}
+		fsys.addFile(name, int64(len(data)), hdr.ModTime, func() ([]byte, error) { return data, nil })
+	}
+	return total, nil
+}
+
+// newZipFS reads a zip archive into a filesystem. Files are decompressed when first opened.
+func newZipFS(content []byte) (*memFS, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newMemFS()
+	var total uint64
+	for _, f := range zr.File {
+		name, ok := archiveEntryName(f.Name)
+		if !ok {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			fsys.addDir(name)
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		total += f.UncompressedSize64
+		if total > maxArchiveSize {
+			return nil, fmt.Errorf("extracted size exceeds %d bytes", maxArchiveSize)
+		}
+		fsys.addFile(name, int64(f.UncompressedSize64), f.Modified, zipFileReader(f))
+	}
+	return fsys, nil
+}
+
+// zipFileReader returns a function decompressing the zip file f.
+func zipFileReader(f *zip.File) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)))
+	}
+}
+
+// archiveEntryName converts the name of an archive entry to a filesystem name.
+// It reports false for the root and for names leaving the archive.
+func archiveEntryName(name string) (string, bool) {
+	name = cleanMemPath(path.Clean("/" + name))
+	return name, name != "." && fs.ValidPath(name)
+}
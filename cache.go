@@ -0,0 +1,217 @@
+package caddyyaml
+
+import (
+	"crypto/sha256"
+	"maps"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// parseCache holds the parsed form of config files across adapter runs, so a reload
+// only parses the files that changed since the previous run. Files not used by the latest
+// run are dropped, so the cache does not grow with paths of past git commits and archives.
+var parseCache = &sourceCache{entries: make(map[string]*cacheEntry)}
+
+// cacheKey identifies the content of a config file.
+// The modification time and size are zero for files that cannot be stat'ed, such as the root config.
+type cacheKey struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// cacheEntry holds the parse results of one version of a file.
+// Results depending on template values are kept for the latest values only.
+type cacheEntry struct {
+	key cacheKey
+	// run is the latest adapter run using the entry
+	run uint64
+
+	includes       []includeConfig
+	includesParsed bool
+
+	varsKey [sha256.Size]byte
+	vars    map[string]any
+	locals  map[string]any
+
+	configKey [sha256.Size]byte
+	config    map[string]any
+	positions map[string]Position
+}
+
+// sourceCache is a concurrency safe cache of parse results keyed by file path.
+// Only the latest version of a file is kept.
+type sourceCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	// run counts the adapter runs
+	run uint64
+}
+
+// startRun starts an adapter run, returning its number for prune.
+func (c *sourceCache) startRun() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.run++
+	return c.run
+}
+
+// prune removes the entries not used since the adapter run started with startRun.
+// Entries used by runs started later, which may be in progress, are kept.
+func (c *sourceCache) prune(run uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path, e := range c.entries {
+		if e.run < run {
+			delete(c.entries, path)
+		}
+	}
+}
+
+// sourceKey computes the cache key of the file at path with the given content.
+func (l *includeLoader) sourceKey(path string, content []byte) cacheKey {
+	key := cacheKey{hash: sha256.Sum256(content)}
+	if info, err := l.stat(path); err == nil {
+		key.modTime = info.ModTime()
+		key.size = info.Size()
+	}
+	return key
+}
+
+// entry returns the entry of path, replacing it with an empty one if the file changed,
+// and marks it as used by the current run. The cache must be locked.
+func (c *sourceCache) entry(path string, key cacheKey) *cacheEntry {
+	e, ok := c.entries[path]
+	if !ok || e.key != key {
+		e = &cacheEntry{key: key}
+		c.entries[path] = e
+	}
+	e.run = c.run
+	return e
+}
+
+// includes returns the include section of a source, parsing it on a cache miss.
+func (c *sourceCache) includes(src source) ([]includeConfig, error) {
+	c.mu.Lock()
+	e := c.entry(src.path, src.key)
+	includes, ok := e.includes, e.includesParsed
+	c.mu.Unlock()
+
+	if ok {
+		logCacheHit(src.path, "include")
+		return includes, nil
+	}
+
+	includes, err := parseIncludeSection(src.body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	e = c.entry(src.path, src.key)
+	e.includes, e.includesParsed = includes, true
+	c.mu.Unlock()
+	return includes, nil
+}
+
+// vars returns the x- and x-local- variables of a source, parsing them on a cache miss.
+func (c *sourceCache) vars(src source, tc *templateContext) (vars, locals map[string]any, err error) {
+	varsKey := tc.key
+
+	c.mu.Lock()
+	e := c.entry(src.path, src.key)
+	if e.vars != nil && e.varsKey == varsKey {
+		vars = copyValue(e.vars).(map[string]any)
+		locals = copyValue(e.locals).(map[string]any)
+	}
+	c.mu.Unlock()
+
+	if vars != nil {
+		logCacheHit(src.path, "vars")
+		return vars, locals, nil
+	}
+
+	vars, locals, err = parseExtensionVars(src.path, src.body, tc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	e = c.entry(src.path, src.key)
+	e.varsKey = varsKey
+	e.vars = copyValue(vars).(map[string]any)
+	e.locals = copyValue(locals).(map[string]any)
+	c.mu.Unlock()
+	return vars, locals, nil
+}
+
+// decode returns the config of a templated source, decoding it on a cache miss.
+// Sources containing file tags depend on other files and are always decoded.
+func (c *sourceCache) decode(src source, body []byte, r *tagResolver) (map[string]any, map[string]Position, error) {
+	configKey := sha256.Sum256(body)
+
+	c.mu.Lock()
+	e := c.entry(src.path, src.key)
+	var (
+		config    map[string]any
+		positions map[string]Position
+	)
+	if e.config != nil && e.configKey == configKey {
+		config = copyValue(e.config).(map[string]any)
+		positions = maps.Clone(e.positions)
+	}
+	c.mu.Unlock()
+
+	if config != nil {
+		logCacheHit(src.path, "config")
+		return config, positions, nil
+	}
+
+	config, positions, err := decodeSource(src, body, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.tagged > 0 {
+		return config, positions, nil
+	}
+
+	c.mu.Lock()
+	e = c.entry(src.path, src.key)
+	e.configKey = configKey
+	e.config = copyValue(config).(map[string]any)
+	e.positions = maps.Clone(positions)
+	c.mu.Unlock()
+	return config, positions, nil
+}
+
+// logCacheHit logs that the parse results of stage were reused for file.
+func logCacheHit(file, stage string) {
+	caddy.Log().Named("adapters.yaml").Debug("parse cache hit",
+		zap.String("file", file),
+		zap.String("stage", stage))
+}
+
+// copyValue deep copies a decoded config value, so cached values are not modified by merging.
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, inner := range v {
+			m[key] = copyValue(inner)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, inner := range v {
+			s[i] = copyValue(inner)
+		}
+		return s
+	case mergeOverride:
+		return mergeOverride{value: copyValue(v.value)}
+	}
+	return value
+}
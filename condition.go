@@ -0,0 +1,74 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conditionOperand matches a variable, a value and a quoted string in a comparison shorthand.
+const conditionOperand = `(\$\w+|\.[\w.]+|"(?:[^"\\]|\\.)*")`
+
+// conditionComparisonRegexp matches the comparison shorthand of include conditions, such as $ENVIRONMENT == "production".
+var conditionComparisonRegexp = regexp.MustCompile(`^\s*` + conditionOperand + `\s*(==|!=)\s*` + conditionOperand + `\s*$`)
+
+// parseIncludeCondition parses the if field of an include entry.
+func parseIncludeCondition(configMap map[string]any, index int, inc *includeConfig) error {
+	ifValue, exists := configMap["if"]
+	if !exists {
+		return nil
+	}
+
+	cond, ok := ifValue.(string)
+	if !ok || strings.TrimSpace(cond) == "" {
+		return fmt.Errorf("include[%d].if must be a non-empty string", index)
+	}
+	inc.If = cond
+	return nil
+}
+
+// includeCondition evaluates the if condition of an include entry of src.
+// A condition is either a comparison shorthand, a template pipeline such as `and $TLS (eq .env "prod")`,
+// or a template rendering to true or false. It is evaluated with the environment variables and the
+// values of the including file.
+func (l *includeLoader) includeCondition(src source, inc includeConfig) (bool, error) {
+	vars, err := l.templateVars(src)
+	if err != nil {
+		return false, err
+	}
+
+	d := l.tc.fileDelims(src.body)
+	out, err := applyTemplate(src.path, []byte(conditionTemplate(inc.If, d)), vars, l.tc, d)
+	if err != nil {
+		return false, fmt.Errorf("%s:%d: include condition %q: %s", src.path, inc.line, inc.If, templateErrorMessage(err))
+	}
+
+	result := strings.TrimSpace(string(out))
+	if result == "" {
+		return false, nil
+	}
+	ok, err := strconv.ParseBool(result)
+	if err != nil {
+		return false, fmt.Errorf("%s:%d: include condition %q must render to true or false, got %q", src.path, inc.line, inc.If, result)
+	}
+	return ok, nil
+}
+
+// conditionTemplate converts an include condition into a template rendering to true or false.
+// Conditions containing the opening delimiter of d are already templates and are returned unchanged.
+func conditionTemplate(cond string, d delimiters) string {
+	if strings.Contains(cond, d.open) {
+		return cond
+	}
+
+	pipeline := cond
+	if m := conditionComparisonRegexp.FindStringSubmatch(cond); m != nil {
+		fn := "eq"
+		if m[2] == "!=" {
+			fn = "ne"
+		}
+		pipeline = fmt.Sprintf("%s %s %s", fn, m[1], m[3])
+	}
+	return d.wrap("if "+pipeline) + "true" + d.wrap("else") + "false" + d.wrap("end")
+}
@@ -0,0 +1,123 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// delimsHeaderRegexp matches the header choosing the template delimiters of a file,
+// e.g. `# caddy-yaml: delims #{{ }}`, on its first line.
+var delimsHeaderRegexp = regexp.MustCompile(`^#[ \t]*caddy-yaml:[ \t]*delims[ \t]+(\S+)[ \t]+(\S+)[ \t]*(?:\r?\n|$)`)
+
+// delimiters are the opening and closing delimiters of template actions.
+type delimiters struct {
+	open, close string
+}
+
+// defaultDelimiters are used by files without a delims header, unless changed by the delimiters setting.
+var defaultDelimiters = delimiters{openingDelim, closingDelim}
+
+// wrap wraps a string with the delimiters.
+func (d delimiters) wrap(s string) string {
+	return fmt.Sprintf("%s %s %s", d.open, s, d.close)
+}
+
+// String implements fmt.Stringer.
+func (d delimiters) String() string {
+	return d.open + " " + d.close
+}
+
+// parseDelimiters reads a delimiters setting, a list of the opening and closing delimiter.
+func parseDelimiters(value any) (delimiters, bool) {
+	var list []string
+	switch v := value.(type) {
+	case []string:
+		list = v
+	case []any:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return delimiters{}, false
+			}
+			list = append(list, s)
+		}
+	}
+	if len(list) != 2 {
+		return delimiters{}, false
+	}
+	d := delimiters{list[0], list[1]}
+	return d, d.valid()
+}
+
+// valid reports whether the delimiters are non-empty and without whitespace.
+func (d delimiters) valid() bool {
+	for _, delim := range []string{d.open, d.close} {
+		if delim == "" || strings.ContainsFunc(delim, unicode.IsSpace) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileDelims returns the delimiters of the file with the given body: those of its delims header,
+// or the delimiters of tc.
+func (tc *templateContext) fileDelims(body []byte) delimiters {
+	if m := delimsHeaderRegexp.FindSubmatch(body); m != nil {
+		return delimiters{string(m[1]), string(m[2])}
+	}
+	return tc.delims
+}
+
+// stripDelimsHeader blanks the delims header of body, which would otherwise be parsed as an action.
+// The newline is kept, so the lines of the templated output match the lines of the source file.
+func stripDelimsHeader(body string) string {
+	loc := delimsHeaderRegexp.FindStringIndex(body)
+	if loc == nil {
+		return body
+	}
+	if strings.HasSuffix(body[:loc[1]], "\n") {
+		return "\n" + body[loc[1]:]
+	}
+	return body[loc[1]:]
+}
+
+// rawBlockRegexps returns the regexps matching the start and end markers of raw blocks,
+// e.g. `#{ raw }` and `#{ endraw }`.
+func (d delimiters) rawBlockRegexps() (start, end *regexp.Regexp) {
+	marker := func(name string) *regexp.Regexp {
+		return regexp.MustCompile(regexp.QuoteMeta(d.open) + `\s*` + name + `\s*` + regexp.QuoteMeta(d.close))
+	}
+	return marker("raw"), marker("endraw")
+}
+
+// escapeRawBlocks replaces the raw blocks of body by their content, with opening delimiters escaped
+// so they render literally. Markers are removed without their line breaks, so lines are preserved.
+// It returns the line of an unterminated raw block, or zero.
+func escapeRawBlocks(body string, d delimiters) (string, int) {
+	if !strings.Contains(body, d.open) {
+		return body, 0
+	}
+	start, end := d.rawBlockRegexps()
+	escaped := d.wrap(fmt.Sprintf("%q", d.open))
+
+	var b strings.Builder
+	rest := body
+	for {
+		loc := start.FindStringIndex(rest)
+		if loc == nil {
+			b.WriteString(rest)
+			return b.String(), 0
+		}
+		b.WriteString(rest[:loc[0]])
+		content := rest[loc[1]:]
+		endLoc := end.FindStringIndex(content)
+		if endLoc == nil {
+			offset := len(body) - len(rest) + loc[0]
+			return "", strings.Count(body[:offset], "\n") + 1
+		}
+		b.WriteString(strings.ReplaceAll(content[:endLoc[0]], d.open, escaped))
+		rest = content[endLoc[1]:]
+	}
+}
@@ -8,6 +8,7 @@ package caddyyaml
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -15,6 +16,9 @@ import (
 
 var extensionLineRegexp = regexp.MustCompile(`^x\-([a-zA-Z0-9\.\_\-]+)(\s*)\:`)
 
+// localExtensionPrefix prefixes the x- fields that are only visible to templates in the file defining them.
+const localExtensionPrefix = "x-local-"
+
 // removeExtensions removes only top-level x- prefixed keys from the config.
 // Nested x- fields are preserved. This follows the Docker Compose convention
 // where extension fields are only meaningful at the document root.
@@ -28,43 +32,69 @@ func removeExtensions(m map[string]any) {
 }
 
 // extractRawExtensions extracts x- prefixed extension fields from the body.
+// Other lines are left empty, so the fields keep their line numbers in template errors.
 func extractRawExtensions(body []byte) ([]byte, error) {
-	extensions, _ := extractAllMatchingTopLevelSections(body, extensionLineRegexp)
-	return extensions, nil
+	return blankOtherSections(body, extensionLineRegexp), nil
 }
 
 // parseExtensionVars parses YAML and extracts x- variables for templates.
 // It uses line-based extraction to preserve raw YAML structure (including anchors),
 // then applies template processing to the extension fields themselves.
-func parseExtensionVars(body []byte, env []string) (map[string]any, error) {
+// The x-local- fields are returned separately in locals, without their prefix.
+func parseExtensionVars(name string, body []byte, tc *templateContext) (vars, locals map[string]any, err error) {
 	// Extract raw x- field lines (preserves YAML anchors and structure)
+	// The delims header is on a line outside of the fields, so the delimiters are read first
+	delims := tc.fileDelims(body)
 	varsBytes, err := extractRawExtensions(body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Apply templates to x- fields using only env vars
 	// This allows x- fields to reference environment variables
-	varsBytes, err = applyTemplate(varsBytes, nil, env, nil)
+	varsBytes, err = applyTemplate(name, varsBytes, nil, tc, delims)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Parse the processed x- fields
 	var tmp map[string]any
 	if err := yaml.Unmarshal(varsBytes, &tmp); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Create vars map with x- prefix removed
+	// Create vars maps with x- or x-local- prefix removed
 	// Convert hyphens to underscores for template variable names
-	vars := make(map[string]any)
+	vars = make(map[string]any)
+	locals = make(map[string]any)
 	for xkey, val := range tmp {
-		key := xkey[2:] // Remove x- prefix
+		target, key := vars, xkey[2:] // Remove x- prefix
+		if local, ok := strings.CutPrefix(xkey, localExtensionPrefix); ok {
+			target, key = locals, local
+		}
 		// Replace hyphens with underscores for template compatibility
 		key = strings.ReplaceAll(key, "-", "_")
-		vars[key] = val
+		target[key] = val
+	}
+
+	return vars, locals, nil
+}
+
+// namespaceVars places vars under the nested keys of namespace.
+func namespaceVars(vars map[string]any, namespace []string) map[string]any {
+	for _, name := range slices.Backward(namespace) {
+		vars = map[string]any{name: vars}
 	}
+	return vars
+}
 
-	return vars, nil
+// sourceVars returns the values src is templated with: the global values overlaid with the values
+// of its namespace, its x-local- fields and the vars of its include entry, in order of precedence.
+func sourceVars(vars map[string]any, src source, locals map[string]any) map[string]any {
+	ns := vars
+	for _, name := range src.scope.namespace {
+		ns, _ = ns[name].(map[string]any)
+		vars = overlayVars(vars, ns)
+	}
+	return overlayVars(overlayVars(vars, locals), src.scope.vars)
 }
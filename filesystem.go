@@ -0,0 +1,248 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	filesystems   = make(map[string]fs.FS)
+	filesystemsMu sync.RWMutex
+)
+
+var fsNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// RegisterFS registers a filesystem that include paths prefixed with name:// are read from.
+// This allows custom Caddy builds to ship base configs, e.g. an embed.FS registered as "embed"
+// in an init function makes "embed://base/logging.yaml" include base/logging.yaml from it.
+// It panics if the name is invalid or already registered.
+func RegisterFS(name string, fsys fs.FS) {
+	if !fsNameRegexp.MatchString(name) || name == "http" || name == "https" {
+		panic(fmt.Sprintf("invalid filesystem name %q", name))
+	}
+
+	filesystemsMu.Lock()
+	defer filesystemsMu.Unlock()
+	if _, ok := filesystems[name]; ok {
+		panic(fmt.Sprintf("filesystem %q already registered", name))
+	}
+	filesystems[name] = fsys
+}
+
+// lookupFS returns the filesystem registered under name.
+func lookupFS(name string) (fs.FS, bool) {
+	filesystemsMu.RLock()
+	defer filesystemsMu.RUnlock()
+	fsys, ok := filesystems[name]
+	return fsys, ok
+}
+
+// splitScheme splits an include path of the form scheme://rest.
+func splitScheme(p string) (scheme, rest string, ok bool) {
+	scheme, rest, ok = strings.Cut(p, "://")
+	if !ok || !fsNameRegexp.MatchString(scheme) {
+		return "", p, false
+	}
+	return scheme, rest, true
+}
+
+// joinIncludePath resolves an include path relative to baseDir.
+// Paths with a scheme and absolute paths are returned unchanged, except for being cleaned.
+func joinIncludePath(baseDir, p string) string {
+	if scheme, rest, ok := splitScheme(p); ok {
+		return scheme + "://" + path.Clean(rest)
+	}
+	if scheme, rest, ok := splitScheme(baseDir); ok && !path.IsAbs(filepath.ToSlash(p)) {
+		return scheme + "://" + path.Join(rest, filepath.ToSlash(p))
+	}
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(baseDir, p)
+}
+
+// dirIncludePath returns the directory of an include path.
+func dirIncludePath(p string) string {
+	if scheme, rest, ok := splitScheme(p); ok {
+		return scheme + "://" + path.Dir(rest)
+	}
+	return filepath.Dir(p)
+}
+
+// resolveFS returns the filesystem an include path is read from and the name of the file within it.
+// A nil filesystem means the path is read from the operating system. Paths with a scheme are read
+// from the mounted git tree or archive or the registered filesystem, other paths from the filesystem set in the
+// adapter options, if any.
+func (l *includeLoader) resolveFS(p string) (fs.FS, string, error) {
+	if scheme, rest, ok := splitScheme(p); ok {
+		var fsys fs.FS
+		if mount, ok := l.mounts[scheme]; ok {
+			fsys = mount
+		} else if fsys, ok = lookupFS(scheme); !ok {
+			return nil, "", fmt.Errorf("no filesystem registered for %s://", scheme)
+		}
+		name, err := fsName(rest)
+		return fsys, name, err
+	}
+
+	if l.fsys != nil {
+		name, err := fsName(p)
+		return l.fsys, name, err
+	}
+
+	return nil, p, nil
+}
+
+// fsName converts an include path to a name valid in an fs.FS.
+func fsName(p string) (string, error) {
+	name := path.Clean(strings.TrimLeft(filepath.ToSlash(p), "/"))
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid path %s: paths cannot leave the filesystem root", p)
+	}
+	return name, nil
+}
+
+// stat returns the file info of an include path after checking it may be included.
+func (l *includeLoader) stat(p string) (fs.FileInfo, error) {
+	fsys, name, err := l.resolveFS(p)
+	if err != nil {
+		return nil, err
+	}
+	if fsys != nil {
+		return fs.Stat(fsys, name)
+	}
+
+	if err := l.checkPath(p); err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+// readDir reads an included directory after checking it may be included.
+func (l *includeLoader) readDir(p string) ([]fs.DirEntry, error) {
+	fsys, name, err := l.resolveFS(p)
+	if err != nil {
+		return nil, err
+	}
+	if fsys != nil {
+		return fs.ReadDir(fsys, name)
+	}
+
+	if err := l.checkPath(p); err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+// readFile reads an included file after checking it may be included and accounts for its size.
+func (l *includeLoader) readFile(p string) ([]byte, error) {
+	fsys, name, err := l.resolveFS(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if fsys == nil {
+		if err := l.checkPath(p); err != nil {
+			return nil, err
+		}
+		fsys, name = osFS{}, p
+	}
+
+	// Files larger than the remaining size are rejected before reading them into memory
+	remaining := int64(-1)
+	if l.settings.maxIncludeSize > 0 {
+		remaining = int64(l.settings.maxIncludeSize - l.size)
+		if info, err := fs.Stat(fsys, name); err == nil && info.Size() > remaining {
+			return nil, l.sizeLimitError(p)
+		}
+	}
+
+	content, err := readAll(fsys, name, remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.account(p, len(content)); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// readAll reads the file name of fsys. If limit is not negative, at most limit+1 bytes are read,
+// which caps files that do not report their size, like devices.
+func readAll(fsys fs.FS, name string, limit int64) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit+1)
+	}
+	return io.ReadAll(r)
+}
+
+// osFS opens files by their OS paths, unlike os.DirFS which only accepts slash-separated relative paths.
+type osFS struct{}
+
+// Open implements fs.FS.
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// walkDir walks the directory tree at the include path root in lexical order, calling fn with the
+// include path and the slash-separated path relative to root of every file or directory.
+func (l *includeLoader) walkDir(root string, fn func(p, rel string, d fs.DirEntry, err error) error) error {
+	fsys, name, err := l.resolveFS(root)
+	if err != nil {
+		return err
+	}
+
+	if fsys == nil {
+		return l.walkOSDir(root, fn)
+	}
+
+	return fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		rel := "."
+		if p != name {
+			rel = strings.TrimPrefix(p, name+"/")
+			if name == "." {
+				rel = p
+			}
+		}
+		return fn(joinIncludePath(root, rel), rel, d, err)
+	})
+}
+
+// walkOSDir walks the directory tree at the OS path root like walkDir, after checking it may be included.
+// Symlinks to directories outside the include root are skipped.
+func (l *includeLoader) walkOSDir(root string, fn func(p, rel string, d fs.DirEntry, err error) error) error {
+	// Listing a directory outside the include root would leak the names of its files
+	if err := l.checkPath(root); err != nil {
+		return err
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && l.symlinkOutsideRoot(p, d) {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return relErr
+		}
+		return fn(p, filepath.ToSlash(rel), d, err)
+	})
+}
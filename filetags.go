@@ -0,0 +1,324 @@
+package caddyyaml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File tags read files relative to the directory of the file containing them.
+const (
+	// includeTag splices the content of the referenced files at the tagged node.
+	includeTag = "!include"
+	// fileTag replaces the tagged node with the content of the file as a string.
+	fileTag = "!file"
+	// fileBase64Tag replaces the tagged node with the base64 encoded content of the file.
+	fileBase64Tag = "!file_base64"
+)
+
+// tagResolver resolves the file tags in a templated source. Tagged files are read through
+// the include loader and templated with the same values as the file they are included from.
+type tagResolver struct {
+	loader *includeLoader
+	vars   map[string]any
+	tc     *templateContext
+
+	// files records the file of nodes spliced in from other files
+	files map[*yaml.Node]string
+	// tagged counts the resolved file tags
+	tagged int
+}
+
+// newTagResolver creates a tag resolver templating tagged files with vars.
+func newTagResolver(loader *includeLoader, vars map[string]any, tc *templateContext) *tagResolver {
+	return &tagResolver{
+		loader: loader,
+		vars:   vars,
+		tc:     tc,
+		files:  make(map[*yaml.Node]string),
+	}
+}
+
+// resolve replaces the file tags below n, found in the file at path.
+// The included chain is used to detect circular includes.
+func (r *tagResolver) resolve(n *yaml.Node, path string, included []string) error {
+	switch n.Tag {
+	case includeTag:
+		r.tagged++
+		return r.resolveInclude(n, path, included)
+	case fileTag, fileBase64Tag:
+		r.tagged++
+		return r.resolveFile(n, path)
+	}
+
+	for _, child := range n.Content {
+		if err := r.resolve(child, path, included); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveInclude replaces a node tagged !include with the content of the files it references.
+// Sequences from several files are concatenated and mappings are combined.
+func (r *tagResolver) resolveInclude(n *yaml.Node, path string, included []string) error {
+	if n.Kind != yaml.ScalarNode || n.Value == "" {
+		return fmt.Errorf("%s:%d: %s requires a file path or pattern", path, n.Line, includeTag)
+	}
+
+	files, err := r.tagFiles(n.Value, path)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %s %s: %w", path, n.Line, includeTag, n.Value, err)
+	}
+
+	contents := make([]*yaml.Node, 0, len(files))
+	for _, file := range files {
+		content, err := r.includeFile(file, included)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s %s: %w", path, n.Line, includeTag, n.Value, err)
+		}
+		if content != nil {
+			contents = append(contents, content)
+		}
+	}
+
+	spliced, err := r.combine(contents)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %s %s: %w", path, n.Line, includeTag, n.Value, err)
+	}
+
+	file := r.files[spliced]
+	*n = *spliced
+	if file != "" {
+		r.files[n] = file
+	}
+	return nil
+}
+
+// resolveFile replaces a node tagged !file or !file_base64 with the content of the file as a string scalar.
+// The content is used verbatim, without templating.
+func (r *tagResolver) resolveFile(n *yaml.Node, path string) error {
+	if n.Kind != yaml.ScalarNode || n.Value == "" {
+		return fmt.Errorf("%s:%d: %s requires a file path", path, n.Line, n.Tag)
+	}
+
+	file := joinIncludePath(dirIncludePath(path), n.Value)
+
+	content, err := r.loader.readFile(file)
+	if err != nil {
+		return fmt.Errorf("%s:%d: %s %s: %w", path, n.Line, n.Tag, n.Value, err)
+	}
+
+	value := string(content)
+	if n.Tag == fileBase64Tag {
+		value = base64.StdEncoding.EncodeToString(content)
+	}
+
+	*n = yaml.Node{
+		Kind:   yaml.ScalarNode,
+		Tag:    "!!str",
+		Value:  value,
+		Line:   n.Line,
+		Column: n.Column,
+	}
+	return nil
+}
+
+// tagFiles returns the files referenced by a tag value, resolved relative to the file at path.
+func (r *tagResolver) tagFiles(value, path string) ([]string, error) {
+	baseDir := dirIncludePath(path)
+	if hasGlobMeta(value) {
+		files, err := r.loader.expandGlob(value, baseDir, includeConfig{})
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("pattern matched no files")
+		}
+		return files, nil
+	}
+
+	return []string{joinIncludePath(baseDir, value)}, nil
+}
+
+// includeFile reads, templates and parses a tagged file, returning its content node.
+// Extension fields of the file are only visible within the file itself and are removed from its content.
+func (r *tagResolver) includeFile(file string, included []string) (*yaml.Node, error) {
+	// Check for circular includes
+	if slices.Contains(included, file) {
+		return nil, fmt.Errorf("circular include detected: %s", file)
+	}
+	if err := r.loader.checkDepth(included, file); err != nil {
+		return nil, err
+	}
+
+	content, err := r.loader.readFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read include file %s: %w", file, err)
+	}
+
+	localVars, locals, err := parseExtensionVars(file, content, r.tc)
+	if err != nil {
+		return nil, err
+	}
+	vars := maps.Clone(r.vars)
+	if vars == nil {
+		vars = make(map[string]any)
+	}
+	maps.Copy(vars, localVars)
+	maps.Copy(vars, locals)
+
+	content, err = applyTemplate(file, content, vars, r.tc, r.tc.fileDelims(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	node := doc.Content[0]
+	if node.Kind == yaml.MappingNode {
+		removeNodeExtensions(node)
+	}
+
+	if err := r.resolve(node, file, append(slices.Clip(included), file)); err != nil {
+		return nil, err
+	}
+
+	r.files[node] = file
+	return node, nil
+}
+
+// combine splices the content nodes of several files into a single node.
+// Sequences are concatenated and mappings are deep merged like included files.
+func (r *tagResolver) combine(contents []*yaml.Node) (*yaml.Node, error) {
+	switch len(contents) {
+	case 0:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	case 1:
+		return contents[0], nil
+	}
+
+	kind := contents[0].Kind
+	if kind != yaml.SequenceNode && kind != yaml.MappingNode {
+		return nil, fmt.Errorf("only sequences or mappings from several files can be combined")
+	}
+	for _, content := range contents {
+		if content.Kind != kind {
+			return nil, fmt.Errorf("cannot combine %s with %s from %s", nodeKindName(kind), nodeKindName(content.Kind), r.files[content])
+		}
+	}
+
+	if kind == yaml.MappingNode {
+		return r.mergeMappings(contents)
+	}
+	return r.concatSequences(contents), nil
+}
+
+// concatSequences concatenates the sequence nodes of several files. The items keep the position
+// of the file they came from.
+func (r *tagResolver) concatSequences(contents []*yaml.Node) *yaml.Node {
+	combined := &yaml.Node{Kind: yaml.SequenceNode, Tag: contents[0].Tag}
+	for _, content := range contents {
+		file := r.files[content]
+		for _, n := range content.Content {
+			if _, ok := r.files[n]; !ok {
+				r.files[n] = file
+			}
+		}
+		combined.Content = append(combined.Content, content.Content...)
+	}
+	return combined
+}
+
+// mergeMappings decodes the mapping nodes of several files and deep merges them with the merger
+// of included files, so !reset, !override and conflicts behave the same. The merged mapping is
+// encoded back into a node, positioned where the merged values were defined.
+func (r *tagResolver) mergeMappings(contents []*yaml.Node) (*yaml.Node, error) {
+	merged := make(map[string]any)
+	prov := NewProvenance()
+	for _, content := range contents {
+		positions := make(map[string]Position)
+		nodePositions(r.files[content], content, "", positions, r.files)
+
+		value, err := decodeNode(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", r.files[content], err)
+		}
+		m := &merger{prov: prov, positions: positions}
+		if err := m.mergeMap(merged, value, "", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var n yaml.Node
+	if err := n.Encode(merged); err != nil {
+		return nil, err
+	}
+	r.locateNodes(&n, "", prov)
+	return &n, nil
+}
+
+// locateNodes positions the nodes below n, encoded from a merged value, where prov recorded
+// their values were defined, keyed by their JSON pointer relative to pointer.
+// As in the files they came from, values in mappings are positioned at their key.
+func (r *tagResolver) locateNodes(n *yaml.Node, pointer string, prov *Provenance) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			keyPointer := joinPointer(pointer, key.Value)
+			r.locateNode(key, keyPointer, prov)
+			r.locateNodes(n.Content[i+1], keyPointer, prov)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			itemPointer := joinPointer(pointer, strconv.Itoa(i))
+			r.locateNode(item, itemPointer, prov)
+			r.locateNodes(item, itemPointer, prov)
+		}
+	}
+}
+
+// locateNode positions the node n of the value at pointer where prov recorded it was defined.
+func (r *tagResolver) locateNode(n *yaml.Node, pointer string, prov *Provenance) {
+	if pos, ok := prov.Lookup(pointer); ok {
+		n.Line, n.Column = pos.Line, pos.Column
+		r.files[n] = pos.File
+	}
+}
+
+// removeNodeExtensions removes the x- prefixed keys of a mapping node.
+func removeNodeExtensions(n *yaml.Node) {
+	content := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if strings.HasPrefix(n.Content[i].Value, "x-") {
+			continue
+		}
+		content = append(content, n.Content[i], n.Content[i+1])
+	}
+	n.Content = content
+}
+
+// nodeKindName returns a readable name of a node kind for error messages.
+func nodeKindName(kind yaml.Kind) string {
+	switch kind {
+	case yaml.SequenceNode:
+		return "sequence"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		return "scalar"
+	}
+	return "node"
+}
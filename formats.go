@@ -0,0 +1,134 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// sourceFormat is the format of a config file taking part in the adapted config.
+type sourceFormat int
+
+const (
+	// formatYAML files are templated, may include other files and define x- variables.
+	formatYAML sourceFormat = iota
+	// formatJSON files hold native Caddy JSON and are merged as they are.
+	formatJSON
+	// formatCaddyfile files are adapted to Caddy JSON by the caddyfile adapter before merging.
+	formatCaddyfile
+)
+
+// formatNames are the names of the formats in the formats field of include entries.
+var formatNames = map[string]sourceFormat{
+	"yaml":      formatYAML,
+	"json":      formatJSON,
+	"caddyfile": formatCaddyfile,
+}
+
+// caddyfileAdapterName is the name of the registered config adapter used for Caddyfile includes.
+const caddyfileAdapterName = "caddyfile"
+
+// detectFormat returns the format of the config file at p from its name: a .yaml, .yml, .json or
+// .caddyfile extension, or the name Caddyfile. It reports false for files that are not config files,
+// such as editor backups like Caddyfile~.
+func detectFormat(p string) (sourceFormat, bool) {
+	base := path.Base(filepath.ToSlash(p))
+	switch strings.ToLower(path.Ext(base)) {
+	case ".yaml", ".yml":
+		return formatYAML, true
+	case ".json":
+		return formatJSON, true
+	case ".caddyfile":
+		return formatCaddyfile, true
+	}
+	if base == "Caddyfile" {
+		return formatCaddyfile, true
+	}
+	return formatYAML, false
+}
+
+// walksFormat reports whether directory includes of the entry pick up files of the format.
+// Only YAML files are picked up unless the formats field lists others.
+func (inc includeConfig) walksFormat(format sourceFormat) bool {
+	if inc.Formats == nil {
+		return format == formatYAML
+	}
+	return slices.Contains(inc.Formats, format)
+}
+
+// parseIncludeFormats parses the formats field of an include entry.
+func parseIncludeFormats(configMap map[string]any, index int, inc *includeConfig) error {
+	value, exists := configMap["formats"]
+	if !exists {
+		return nil
+	}
+	names, err := parseIncludeStringList(value, "formats", index)
+	if err != nil {
+		return err
+	}
+	inc.Formats = make([]sourceFormat, 0, len(names))
+	for _, name := range names {
+		format, ok := formatNames[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("include[%d].formats: unknown format %q, must be yaml, json or caddyfile", index, name)
+		}
+		inc.Formats = append(inc.Formats, format)
+	}
+	return nil
+}
+
+// loadSource loads the content of the included file at p according to its format.
+// Files of an unknown format are loaded as YAML in the given include scope.
+func (l *includeLoader) loadSource(content []byte, p string, scope includeScope, included []string) ([]source, error) {
+	format, _ := detectFormat(p)
+	switch format {
+	case formatJSON:
+		return []source{{path: p, body: content, key: l.sourceKey(p, content), format: format, included: included}}, nil
+	case formatCaddyfile:
+		adapted, err := l.adaptCaddyfile(content, p)
+		if err != nil {
+			return nil, err
+		}
+		return []source{{path: p, body: adapted, key: l.sourceKey(p, content), format: format, included: included}}, nil
+	}
+	return l.processIncludes(content, p, scope, included)
+}
+
+// adaptCaddyfile adapts an included Caddyfile to Caddy JSON with the registered caddyfile adapter,
+// forwarding its warnings.
+func (l *includeLoader) adaptCaddyfile(content []byte, p string) ([]byte, error) {
+	adapter := caddyconfig.GetAdapter(caddyfileAdapterName)
+	if adapter == nil {
+		return nil, fmt.Errorf("cannot include Caddyfile %s: the %s adapter is not registered", p, caddyfileAdapterName)
+	}
+
+	adapted, warnings, err := adapter.Adapt(content, map[string]any{"filename": p})
+	for _, w := range warnings {
+		file := w.File
+		if file == "" {
+			file = p
+		}
+		l.wc.AddFile(file, w.Line, w.Directive, w.Message)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to adapt Caddyfile %s: %w", p, err)
+	}
+	return adapted, nil
+}
+
+// locateCaddyfile points the positions of an adapted Caddyfile at the Caddyfile itself,
+// since the adapted JSON has no lines in common with it.
+func locateCaddyfile(src source, positions map[string]Position) {
+	for pointer := range positions {
+		positions[pointer] = Position{File: src.path}
+	}
+}
+
+// templated reports whether the source is processed as a template.
+func (src source) templated() bool {
+	return src.format == formatYAML
+}
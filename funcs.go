@@ -0,0 +1,96 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// nonDeterministicFuncs are the template functions whose result differs between runs
+// given the same inputs. They are unavailable in reproducible mode.
+var nonDeterministicFuncs = []string{
+	// time
+	"now", "ago", "date_in_zone", "dateInZone", "date_modify", "dateModify", "mustDateModify",
+	// randomness
+	"randAlpha", "randAlphaNum", "randAscii", "randNumeric", "randBytes", "randInt", "shuffle",
+	"uuidv4", "bcrypt", "htpasswd", "encryptAES",
+	// keys and certificates
+	"genPrivateKey", "genCA", "genCAWithKey", "genSelfSignedCert", "genSelfSignedCertWithKey",
+	"genSignedCert", "genSignedCertWithKey",
+	// network
+	"getHostByName",
+}
+
+// templateFuncs returns the functions available to templates with the settings s: the sprig functions,
+// with env, expandenv and required reading the environment of tc. Functions withheld by the allow
+// and deny lists or by reproducible mode are replaced by functions failing with the reason.
+func templateFuncs(s settings, tc *templateContext) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["env"] = tc.envFunc
+	funcs["expandenv"] = tc.expandEnvFunc
+	funcs["required"] = tc.requiredFunc
+
+	for name := range funcs {
+		switch {
+		case s.allowFuncs != nil && !slices.Contains(s.allowFuncs, name):
+			funcs[name] = unavailableFunc(name, "not in the allowed functions")
+		case slices.Contains(s.denyFuncs, name):
+			funcs[name] = unavailableFunc(name, "denied")
+		case s.reproducible && slices.Contains(nonDeterministicFuncs, name):
+			funcs[name] = unavailableFunc(name, "not available in reproducible mode")
+		}
+	}
+	return funcs
+}
+
+// funcAvailable reports whether the settings s make the template function name available.
+func funcAvailable(s settings, name string) bool {
+	return (s.allowFuncs == nil || slices.Contains(s.allowFuncs, name)) && !slices.Contains(s.denyFuncs, name)
+}
+
+// unavailableFunc returns a template function failing because the function name is unavailable.
+// Keeping the name defined locates the error at its use rather than failing to parse the template.
+func unavailableFunc(name, reason string) func(...any) (any, error) {
+	return func(...any) (any, error) {
+		return nil, fmt.Errorf("function %s is %s", name, reason)
+	}
+}
+
+// parseFuncNames reads a list of template function names, reporting false if any is unknown.
+func parseFuncNames(value any) ([]string, bool) {
+	var names []string
+	switch v := value.(type) {
+	case []string:
+		names = v
+	case []any:
+		names = make([]string, 0, len(v))
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			names = append(names, name)
+		}
+	default:
+		return nil, false
+	}
+
+	known := sprig.TxtFuncMap()
+	for _, name := range names {
+		if _, ok := known[name]; !ok && name != "required" {
+			return nil, false
+		}
+	}
+	return names, true
+}
+
+// expandEnvFunc replaces $VAR and ${VAR} in s with the environment variables of tc.
+// Unlike the sprig function it replaces, it reads the environment the config is adapted with.
+func (tc *templateContext) expandEnvFunc(s string) string {
+	return os.Expand(s, func(key string) string {
+		return tc.env[key]
+	})
+}
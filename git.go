@@ -0,0 +1,141 @@
+package caddyyaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitSchemePrefix prefixes the scheme of include paths read from a git commit.
+// It is followed by the abbreviated commit hash, as in git+0123456789ab://sites/blog.yaml.
+const gitSchemePrefix = "git+"
+
+// gitIncludeDir mounts the git repository of an include entry of src and returns the directory
+// the paths of the entry are relative to. The repository and ref are expanded like include paths.
+func (l *includeLoader) gitIncludeDir(src source, inc includeConfig) (string, error) {
+	repo, err := l.expandIncludePath(src, inc, inc.Git)
+	if err != nil {
+		return "", err
+	}
+	ref, err := l.expandIncludePath(src, inc, inc.Ref)
+	if err != nil {
+		return "", err
+	}
+	// A ref starting with a dash would be passed to git as an option
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("%s:%d: include ref %q must be a branch, tag or commit", src.path, inc.line, ref)
+	}
+
+	scheme, err := l.mountGit(joinIncludePath(dirIncludePath(src.path), repo), ref)
+	if err != nil {
+		return "", fmt.Errorf("%s:%d: %w", src.path, inc.line, err)
+	}
+	return scheme + "://.", nil
+}
+
+// mountGit mounts the tree of the commit ref resolves to in the local git repository at repo,
+// returning the scheme include paths in it are prefixed with.
+func (l *includeLoader) mountGit(repo, ref string) (string, error) {
+	if _, _, ok := splitScheme(repo); ok || isRemoteInclude(repo) {
+		return "", fmt.Errorf("git repository %s must be a local path", repo)
+	}
+	if err := l.checkPath(repo); err != nil {
+		return "", err
+	}
+
+	commit, err := runGit(repo, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve ref %s in git repository %s: %w", ref, repo, err)
+	}
+	commit = strings.TrimSpace(commit)
+
+	scheme := gitSchemePrefix + commit[:min(12, len(commit))]
+	if _, ok := l.mounts[scheme]; ok {
+		return scheme, nil
+	}
+
+	fsys, err := newGitFS(repo, commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to read commit %s of git repository %s: %w", commit, repo, err)
+	}
+	if l.mounts == nil {
+		l.mounts = make(map[string]*memFS)
+	}
+	l.mounts[scheme] = fsys
+	return scheme, nil
+}
+
+// newGitFS creates a filesystem of the tree of commit in the git repository at repo.
+// File contents are read from the repository when they are first opened.
+// Symlinks and submodules are left out.
+func newGitFS(repo, commit string) (*memFS, error) {
+	out, err := runGit(repo, "ls-tree", "-r", "-t", "-l", "-z", "--full-tree", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	modTime, err := gitCommitTime(repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newMemFS()
+	for _, record := range strings.Split(out, "\x00") {
+		// Records have the form "<mode> <type> <object> <size>\t<path>"
+		meta, name, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		mode, typ, object := fields[0], fields[1], fields[2]
+		name = cleanMemPath(name)
+
+		switch {
+		case typ == "tree":
+			fsys.addDir(name)
+		case typ == "blob" && mode != "120000":
+			size, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid size of %s: %q", name, fields[3])
+			}
+			fsys.addFile(name, size, modTime, func() ([]byte, error) {
+				content, err := runGit(repo, "cat-file", "blob", object)
+				return []byte(content), err
+			})
+		}
+	}
+	return fsys, nil
+}
+
+// gitCommitTime returns the commit time of commit in the git repository at repo.
+func gitCommitTime(repo, commit string) (time.Time, error) {
+	commitTime, err := runGit(repo, "show", "-s", "--format=%ct", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(commitTime), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid commit time %q", commitTime)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// runGit runs a git command in the repository at repo and returns its output.
+func runGit(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,156 @@
+package caddyyaml
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hasGlobMeta reports whether the include path contains glob metacharacters.
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// validateGlob checks that every segment of a slash-separated glob pattern is well formed.
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash-separated name matches pattern.
+// Segments are matched with path.Match; a "**" segment matches zero or more segments.
+func matchGlob(pattern, name string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchGlobSegments matches name segments against pattern segments.
+func matchGlobSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			return matchGlobStar(pattern[1:], name)
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// matchGlobStar matches name segments against the pattern segments following a "**" segment,
+// which matches zero or more name segments.
+func matchGlobStar(pattern, name []string) bool {
+	for i := 0; i <= len(name); i++ {
+		if matchGlobSegments(pattern, name[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitGlob splits a slash-separated pattern into its static directory prefix and the remaining pattern.
+func splitGlob(pattern string) (prefix, rest string) {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if hasGlobMeta(segment) {
+			return strings.Join(segments[:i], "/"), strings.Join(segments[i:], "/")
+		}
+	}
+	return pattern, ""
+}
+
+// expandGlob returns the files matching pattern, resolved against baseDir, in lexical order.
+// Only regular files are matched; directories are walked to find matches below them.
+// Hidden files and directories below the static prefix of the pattern are skipped if the include
+// skips hidden files, and directories are descended no deeper than its maximum depth.
+func (l *includeLoader) expandGlob(pattern, baseDir string, inc includeConfig) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+	if err := validateGlob(pattern); err != nil {
+		return nil, err
+	}
+
+	prefix, rest := splitGlob(pattern)
+	root := joinIncludePath(baseDir, prefix)
+
+	var matches []string
+	err := l.walkDir(root, func(p, rel string, d fs.DirEntry, err error) error {
+		file, err := globEntryFile(rel, d, err, inc)
+		if file && matchGlob(rest, rel) {
+			matches = append(matches, p)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand pattern %s: %w", pattern, err)
+	}
+
+	// Directories are walked in lexical order, so matches are already deterministic
+	return matches, nil
+}
+
+// globEntryFile reports whether the walked entry d, at rel below the static prefix of a pattern,
+// is a file to match. It returns the error to continue the walk with.
+// As for directory includes, the static prefix has depth 1.
+func globEntryFile(rel string, d fs.DirEntry, err error, inc includeConfig) (bool, error) {
+	if err != nil {
+		// A missing static prefix simply has no matches
+		if rel == "." && errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if rel == "." {
+		return false, nil
+	}
+	if inc.SkipHidden && isHidden(d.Name()) {
+		if d.IsDir() {
+			return false, fs.SkipDir
+		}
+		return false, nil
+	}
+	if d.IsDir() && inc.MaxDepth > 0 && strings.Count(rel, "/")+1 >= inc.MaxDepth {
+		return false, fs.SkipDir
+	}
+	return !d.IsDir(), nil
+}
+
+// resolveExcludes makes exclude patterns containing a slash relative to baseDir.
+// Patterns without a slash are matched against file names and are returned unchanged.
+func resolveExcludes(patterns []string, baseDir string) []string {
+	resolved := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if strings.Contains(pattern, "/") {
+			pattern = filepath.ToSlash(joinIncludePath(baseDir, pattern))
+		}
+		resolved[i] = pattern
+	}
+	return resolved
+}
+
+// isExcluded reports whether the file at p matches one of the resolved exclude patterns.
+func isExcluded(patterns []string, p string) bool {
+	p = filepath.ToSlash(p)
+	for _, pattern := range patterns {
+		name := p
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(p)
+		}
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,145 +7,513 @@
 package caddyyaml
 
 import (
+	"errors"
 	"fmt"
+	"go/token"
+	"io/fs"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
+var includeLineRegexp = regexp.MustCompile(`^include(\s*)\:`)
+
 // includeConfig represents an include directive in the YAML config.
 type includeConfig struct {
-	Path []string `yaml:"path"`
+	Path       []string       `yaml:"path"`
+	Exclude    []string       `yaml:"exclude"`
+	Required   bool           `yaml:"required"`
+	SHA256     string         `yaml:"sha256"`
+	SkipHidden bool           `yaml:"skip_hidden"`
+	MaxDepth   int            `yaml:"max_depth"`
+	Sort       string         `yaml:"sort"`
+	If         string         `yaml:"if"`
+	Vars       map[string]any `yaml:"vars"`
+	As         string         `yaml:"as"`
+	Git        string         `yaml:"git"`
+	Ref        string         `yaml:"ref"`
+	Subpath    string         `yaml:"subpath"`
+	Formats    []sourceFormat `yaml:"formats"`
+
+	// index is the index of the include entry in the include list
+	index int
+	// line is the line of the include entry in the including file
+	line int
+	// scope is the include scope of the included files, set when the entry is processed
+	scope includeScope
 }
 
-// processIncludes handles include directives by loading and merging referenced files.
-// It detects circular dependencies and returns the merged configuration bytes.
-func processIncludes(body []byte, baseDir string, included []string) ([]byte, error) {
-	var config map[string]any
-	if err := yaml.Unmarshal(body, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML for includes: %w", err)
+// includeScope holds the template values an include entry passes on to the files it includes,
+// and to the files these include in turn.
+type includeScope struct {
+	// vars are the vars of the include entries, overlaid on the global values
+	vars map[string]any
+	// namespace lists the as aliases the x- fields of the files are placed under
+	namespace []string
+}
+
+// enter returns the include scope of the files included by inc from a file in the scope.
+func (scope includeScope) enter(inc includeConfig) includeScope {
+	inner := includeScope{vars: overlayVars(scope.vars, inc.Vars), namespace: scope.namespace}
+	if inc.As != "" {
+		inner.namespace = append(slices.Clip(scope.namespace), inc.As)
 	}
+	return inner
+}
+
+// includeLoader resolves include directives into the sources taking part in the config.
+type includeLoader struct {
+	wc       *warningsCollector
+	settings settings
+	tc       *templateContext
+	cacheDir string
+	client   *http.Client
+
+	// fsys is the filesystem paths without a scheme are read from, if set
+	fsys fs.FS
+	// mounts are the git trees and archives included from, keyed by scheme
+	mounts map[string]*memFS
+
+	// root and resolvedRoot are the absolute include root without and with symlinks resolved
+	root         string
+	resolvedRoot string
+
+	// files and size are the number and total size of the files read so far
+	files int
+	size  int
+}
 
-	// Check if there are any includes
-	includeValue, hasInclude := config["include"]
-	if !hasInclude {
-		return body, nil
+// newIncludeLoader creates an include loader configured from the settings and adapter options,
+// expanding include paths and conditions with the environment and reporting warnings to wc.
+func newIncludeLoader(options map[string]any, s settings, tc *templateContext, wc *warningsCollector) (*includeLoader, error) {
+	l := &includeLoader{
+		wc:       wc,
+		settings: s,
+		tc:       tc,
+		cacheDir: defaultIncludeCacheDir(),
+		client:   defaultHTTPClient,
+	}
+
+	if s.includeRoot != "" {
+		root, err := filepath.Abs(s.includeRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve include root %s: %w", s.includeRoot, err)
+		}
+		l.root, l.resolvedRoot = root, root
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			l.resolvedRoot = resolved
+		}
+	}
+
+	if cacheDir, ok := options[IncludeCacheDirOptionName].(string); ok {
+		l.cacheDir = cacheDir
+	}
+	if client, ok := options[httpClientOptionName].(*http.Client); ok {
+		l.client = client
+	}
+	if fsys, ok := options[FSOptionName].(fs.FS); ok {
+		l.fsys = fsys
 	}
 
-	// Parse include configurations
-	includes, err := loadIncludeConfig(includeValue)
+	return l, nil
+}
+
+// source is a single file taking part in the adapted config.
+// The body is kept as raw text so template directives survive until templating.
+type source struct {
+	path   string
+	body   []byte
+	key    cacheKey
+	format sourceFormat
+
+	scope includeScope
+
+	// included is the chain of files including this source, ending with the source itself
+	included []string
+}
+
+// processIncludes resolves the include directives of the file at path and returns every file
+// taking part in the config in merge order: the file itself first, followed by its includes depth first.
+// Includes are discovered from the raw text without templating. It detects circular dependencies.
+func (l *includeLoader) processIncludes(body []byte, path string, scope includeScope, included []string) ([]source, error) {
+	src := source{path: path, body: body, key: l.sourceKey(path, body), scope: scope, included: included}
+	sources := []source{src}
+
+	includes, err := parseCache.includes(src)
 	if err != nil {
 		return nil, err
 	}
 
-	// Remove include directive from config
-	delete(config, "include")
-
-	// Process each include
 	for _, inc := range includes {
-		for _, path := range inc.Path {
-			if err := processIncludeStatements(path, baseDir, included, config); err != nil {
-				return nil, err
+		incSources, err := l.processIncludeEntry(src, inc)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, incSources...)
+	}
+
+	return sources, nil
+}
+
+// processIncludeEntry returns the sources of an include entry of src, if its condition holds.
+func (l *includeLoader) processIncludeEntry(src source, inc includeConfig) ([]source, error) {
+	if inc.If != "" {
+		ok, err := l.includeCondition(src, inc)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+
+	inc.scope = src.scope.enter(inc)
+
+	baseDir, err := l.includeBaseDir(src, inc)
+	if err != nil {
+		return nil, err
+	}
+
+	excludes, err := l.expandIncludePaths(src, inc, inc.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	inc.Exclude = resolveExcludes(excludes, baseDir)
+
+	paths, err := l.expandIncludePaths(src, inc, inc.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []source
+	for _, incPath := range paths {
+		if inc.Git != "" {
+			incPath = joinIncludePath(baseDir, strings.TrimLeft(incPath, "/"))
+		}
+		incSources, err := l.processIncludeStatements(incPath, src.path, inc, src.included)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, incSources...)
+	}
+	return sources, nil
+}
+
+// includeBaseDir returns the directory the paths of an include entry of src are relative to.
+// Paths of git includes are relative to the root of the repository.
+func (l *includeLoader) includeBaseDir(src source, inc includeConfig) (string, error) {
+	if inc.Git != "" {
+		return l.gitIncludeDir(src, inc)
+	}
+	return dirIncludePath(src.path), nil
+}
+
+// expandIncludePaths expands the include paths or exclude patterns of an include entry of src.
+func (l *includeLoader) expandIncludePaths(src source, inc includeConfig, paths []string) ([]string, error) {
+	expanded := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p, err := l.expandIncludePath(src, inc, p)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, p)
+	}
+	return expanded, nil
+}
+
+// expandIncludePath expands an include path or exclude pattern of an include entry of src.
+// Template directives are applied first, with the environment variables and the values of the
+// including file, followed by $VAR and ${VAR} environment variables and a leading ~.
+func (l *includeLoader) expandIncludePath(src source, inc includeConfig, p string) (string, error) {
+	p, err := l.applyIncludePathTemplate(src, inc, p)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(p, "$") {
+		p = os.Expand(p, func(key string) string {
+			val, ok := l.tc.env[key]
+			if !ok {
+				l.wc.AddFile(src.path, inc.line, "include", fmt.Sprintf("environment variable %s is not set, substituting an empty string", key))
 			}
+			return val
+		})
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := l.homeDir()
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: include path %q: %w", src.path, inc.line, p, err)
+		}
+		p = home + p[1:]
+	}
+
+	return p, nil
+}
+
+// applyIncludePathTemplate applies the template directives of an include path of src, if any.
+func (l *includeLoader) applyIncludePathTemplate(src source, inc includeConfig, p string) (string, error) {
+	d := l.tc.fileDelims(src.body)
+	if !strings.Contains(p, d.open) {
+		return p, nil
+	}
+
+	vars, err := l.templateVars(src)
+	if err != nil {
+		return "", err
+	}
+	out, err := applyTemplate(src.path, []byte(p), vars, l.tc, d)
+	if err != nil {
+		return "", fmt.Errorf("%s:%d: include path %q: %s", src.path, inc.line, p, templateErrorMessage(err))
+	}
+	return string(out), nil
+}
+
+// homeDir returns the home directory a leading ~ of include paths expands to: HOME in the environment
+// of the config, or that of the process unless templates have no access to the environment.
+func (l *includeLoader) homeDir() (string, error) {
+	if home, ok := l.tc.env["HOME"]; ok {
+		return home, nil
+	}
+	if !l.tc.envAccess {
+		return "", errors.New("HOME is not available without the env function")
+	}
+	return os.UserHomeDir()
+}
+
+// templateVars returns the values include conditions and paths of src are templated with:
+// the x- and x-local- fields of src overlaid with the vars of its include entry.
+func (l *includeLoader) templateVars(src source) (map[string]any, error) {
+	vars, locals, err := parseCache.vars(src, l.tc)
+	if err != nil {
+		return nil, err
+	}
+	return overlayVars(overlayVars(vars, locals), src.scope.vars), nil
+}
+
+// overlayVars returns the values of base overlaid with vars, without modifying either.
+func overlayVars(base, vars map[string]any) map[string]any {
+	if len(vars) == 0 {
+		return base
+	}
+	result := make(map[string]any, len(base)+len(vars))
+	maps.Copy(result, base)
+	maps.Copy(result, vars)
+	return result
+}
+
+// parseIncludeSection extracts the top-level include section from the raw body and parses it.
+// Only the include section is parsed, so the rest of the body may still contain template directives.
+func parseIncludeSection(body []byte) ([]includeConfig, error) {
+	section, _ := extractAllMatchingTopLevelSections(body, includeLineRegexp)
+	if len(section) == 0 {
+		return nil, nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(section, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML for includes: %w", err)
+	}
+
+	var config map[string]any
+	if err := root.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML for includes: %w", err)
+	}
+
+	includes, err := loadIncludeConfig(config["include"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Position the include entries in the original body
+	offset := sectionLineOffset(body, includeLineRegexp)
+	if entries := includeEntryNodes(&root); len(entries) == len(includes) {
+		for i, entry := range entries {
+			includes[i].line = entry.Line + offset
 		}
 	}
 
-	// Marshal back to YAML
-	return yaml.Marshal(config)
+	return includes, nil
 }
 
-// processIncludeStatements loads, processes, and merges a single include file or directory into the config.
-// If path is a directory, all .yaml and .yml files in the directory are processed.
-func processIncludeStatements(path, baseDir string, included []string, config map[string]any) error {
+// includeEntryNodes returns the nodes of the entries of the include list in a parsed include section.
+func includeEntryNodes(root *yaml.Node) []*yaml.Node {
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	mapping := root.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "include" && mapping.Content[i+1].Kind == yaml.SequenceNode {
+			return mapping.Content[i+1].Content
+		}
+	}
+	return nil
+}
+
+// processIncludeStatements loads a single include file, directory or glob pattern and returns its sources.
+// If path is a directory, all YAML files in the directory are processed, and the JSON and Caddyfile
+// files if the include lists their formats.
+// Relative paths are resolved from the directory of the including file from.
+func (l *includeLoader) processIncludeStatements(path, from string, inc includeConfig, included []string) ([]source, error) {
+	if isRemoteInclude(from) {
+		resolved, err := resolveRemoteInclude(path, from)
+		if err != nil {
+			return nil, err
+		}
+		path = resolved
+	}
+	if isRemoteInclude(path) {
+		return l.processIncludeRemote(path, from, inc, included)
+	}
+	// Checksums are only verified for remote includes, so pinning a local file would be a silent no-op
+	if inc.SHA256 != "" {
+		return nil, fmt.Errorf("%s:%d: include[%d].sha256 requires a remote include, got %s", from, inc.line, inc.index, path)
+	}
+
+	baseDir := dirIncludePath(from)
+	if hasGlobMeta(path) {
+		return l.processIncludeGlob(path, baseDir, from, inc, included)
+	}
+
 	// Resolve relative paths
-	if !filepath.IsAbs(path) {
-		path = filepath.Join(baseDir, path)
+	path = joinIncludePath(baseDir, path)
+	if isArchive(path) {
+		return l.processIncludeArchive(path, from, inc, included)
 	}
 
 	// Check if path is a directory
-	info, err := os.Stat(path)
+	info, err := l.stat(path)
+	if errors.Is(err, fs.ErrNotExist) && !inc.Required {
+		l.wc.AddFile(from, inc.line, "include", fmt.Sprintf("optional include %s not found, skipping", path))
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to stat path %s: %w", path, err)
+		return nil, fmt.Errorf("failed to stat path %s: %w", path, err)
 	}
 
 	if info.IsDir() {
-		return processIncludeDir(path, included, config)
+		return l.processIncludeDir(path, 1, inc, included)
+	}
+
+	return l.processIncludeSingleFile(path, inc.scope, included)
+}
+
+// processIncludeGlob processes every file matching the glob pattern in lexical order.
+// Files matching one of the exclude patterns of the include are skipped.
+func (l *includeLoader) processIncludeGlob(pattern, baseDir, from string, inc includeConfig, included []string) ([]source, error) {
+	matches, err := l.expandGlob(pattern, baseDir, inc)
+	if err != nil {
+		return nil, err
 	}
 
-	return processIncludeSingleFile(path, included, config)
+	slices.SortStableFunc(matches, func(a, b string) int {
+		return comparePaths(a, b, inc.Sort)
+	})
+
+	var sources []source
+	for _, match := range matches {
+		if isExcluded(inc.Exclude, match) {
+			continue
+		}
+		var matchSources []source
+		if isArchive(match) {
+			matchSources, err = l.processIncludeArchive(match, from, inc, included)
+		} else {
+			matchSources, err = l.processIncludeSingleFile(match, inc.scope, included)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, matchSources...)
+	}
+
+	if len(sources) == 0 {
+		if !inc.Required {
+			l.wc.AddFile(from, inc.line, "include", fmt.Sprintf("optional include pattern %s matched no files, skipping", pattern))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("include pattern %s matched no files", pattern)
+	}
+
+	return sources, nil
 }
 
 // processIncludeDir recursively processes all YAML files in a directory and its subdirectories.
-func processIncludeDir(dirPath string, included []string, config map[string]any) error {
-	entries, err := os.ReadDir(dirPath)
+// The depth of dirPath starts at 1 for the included directory.
+func (l *includeLoader) processIncludeDir(dirPath string, depth int, inc includeConfig, included []string) ([]source, error) {
+	entries, err := l.readDir(dirPath)
 	if err != nil {
-		return fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+		return nil, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
 	}
 
 	// Process entries in sorted order for deterministic results
+	slices.SortStableFunc(entries, func(a, b fs.DirEntry) int {
+		return comparePaths(a.Name(), b.Name(), inc.Sort)
+	})
+
+	var sources []source
 	for _, entry := range entries {
-		fullPath := filepath.Join(dirPath, entry.Name())
-		err := processIncludeDirEntry(entry, fullPath, included, config)
+		fullPath := joinIncludePath(dirPath, entry.Name())
+		entrySources, err := l.processIncludeDirEntry(entry, fullPath, depth, inc, included)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		sources = append(sources, entrySources...)
 	}
 
-	return nil
+	return sources, nil
 }
 
 // processIncludeDirEntry processes a single directory entry (file or subdirectory).
-func processIncludeDirEntry(entry os.DirEntry, fullPath string, included []string, config map[string]any) error {
+func (l *includeLoader) processIncludeDirEntry(entry fs.DirEntry, fullPath string, depth int, inc includeConfig, included []string) ([]source, error) {
+	if inc.SkipHidden && isHidden(entry.Name()) {
+		return nil, nil
+	}
+
 	if entry.IsDir() {
+		if inc.MaxDepth > 0 && depth >= inc.MaxDepth {
+			return nil, nil
+		}
 		// Recursively process subdirectories
-		return processIncludeDir(fullPath, included, config)
+		return l.processIncludeDir(fullPath, depth+1, inc, included)
 	}
 
-	// Only process .yaml and .yml files
-	ext := filepath.Ext(entry.Name())
-	if ext != ".yaml" && ext != ".yml" {
-		return nil
+	// Only process YAML files, and JSON and Caddyfile files if the entry opts in
+	if format, ok := detectFormat(entry.Name()); !ok || !inc.walksFormat(format) {
+		return nil, nil
 	}
 
-	return processIncludeSingleFile(fullPath, included, config)
+	if isExcluded(inc.Exclude, fullPath) {
+		return nil, nil
+	}
+
+	return l.processIncludeSingleFile(fullPath, inc.scope, included)
 }
 
-// processIncludeSingleFile loads, processes, and merges a single include file into the config.
-func processIncludeSingleFile(path string, included []string, config map[string]any) error {
+// processIncludeSingleFile loads a single include file and the files it includes in turn in the given include scope.
+func (l *includeLoader) processIncludeSingleFile(path string, scope includeScope, included []string) ([]source, error) {
 	// Check for circular includes
 	if slices.Contains(included, path) {
-		return fmt.Errorf("circular include detected: %s", path)
+		return nil, fmt.Errorf("circular include detected: %s", path)
+	}
+	if err := l.checkDepth(included, path); err != nil {
+		return nil, err
 	}
 
 	// Read included file
-	content, err := os.ReadFile(path)
+	content, err := l.readFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read include file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to read include file %s: %w", path, err)
 	}
 
 	// Recursively process includes in the included file
-	includedDir := filepath.Dir(path)
-	newIncluded := append(included, path)
-	content, err = processIncludes(content, includedDir, newIncluded)
-	if err != nil {
-		return err
-	}
-
-	// Parse included content
-	var includedConfig map[string]any
-	if err := yaml.Unmarshal(content, &includedConfig); err != nil {
-		return fmt.Errorf("failed to parse included file %s: %w", path, err)
-	}
-
-	// Merge included config into main config
-	if err := mergeConfig(config, includedConfig); err != nil {
-		return fmt.Errorf("failed to merge include %s: %w", path, err)
-	}
-
-	return nil
+	newIncluded := append(slices.Clip(included), path)
+	return l.loadSource(content, path, scope, newIncluded)
 }
 
 // loadIncludeConfig parses the include configuration from raw YAML.
@@ -165,7 +533,8 @@ func loadIncludeConfig(source any) ([]includeConfig, error) {
 		// Convert string shorthand to map format
 		if v, ok := config.(string); ok {
 			result = append(result, includeConfig{
-				Path: []string{v},
+				Path:     []string{v},
+				Required: true,
 			})
 			continue
 		}
@@ -189,42 +558,219 @@ func parseIncludeMap(config any, index int) (includeConfig, error) {
 		return includeConfig{}, fmt.Errorf("include[%d] must be a string or map, got %T", index, config)
 	}
 
-	var inc includeConfig
+	inc := includeConfig{Required: true, index: index}
+	// The git options depend on the remote options, which are parsed first
+	parsers := []func(map[string]any, int, *includeConfig) error{
+		parseIncludePaths,
+		parseIncludeRemoteOptions,
+		parseIncludeDirOptions,
+		parseIncludeCondition,
+		parseIncludeScopeOptions,
+		parseIncludeArchiveOptions,
+		parseIncludeGitOptions,
+		parseIncludeFormats,
+	}
+	for _, parse := range parsers {
+		if err := parse(configMap, index, &inc); err != nil {
+			return includeConfig{}, err
+		}
+	}
+
+	if requiredValue, exists := configMap["required"]; exists {
+		required, ok := requiredValue.(bool)
+		if !ok {
+			return includeConfig{}, fmt.Errorf("include[%d].required must be a boolean", index)
+		}
+		inc.Required = required
+	}
+
+	return inc, nil
+}
+
+// parseIncludePaths parses the path and exclude fields of an include entry.
+func parseIncludePaths(configMap map[string]any, index int, inc *includeConfig) error {
 	pathValue, exists := configMap["path"]
 	if !exists {
-		return includeConfig{}, fmt.Errorf("include[%d] missing required 'path' field", index)
+		return fmt.Errorf("include[%d] missing required 'path' field", index)
+	}
+
+	paths, err := parseIncludeStringList(pathValue, "path", index)
+	if err != nil {
+		return err
+	}
+
+	// Paths prefixed with ! are negated and act as exclude patterns
+	for _, p := range paths {
+		if pattern, negated := strings.CutPrefix(p, "!"); negated {
+			inc.Exclude = append(inc.Exclude, pattern)
+			continue
+		}
+		inc.Path = append(inc.Path, p)
+	}
+	if len(inc.Path) == 0 {
+		return fmt.Errorf("include[%d].path has no paths to include", index)
+	}
+
+	if excludeValue, exists := configMap["exclude"]; exists {
+		excludes, err := parseIncludeStringList(excludeValue, "exclude", index)
+		if err != nil {
+			return err
+		}
+		inc.Exclude = append(inc.Exclude, excludes...)
+	}
+
+	for _, pattern := range inc.Exclude {
+		if err := validateGlob(filepath.ToSlash(pattern)); err != nil {
+			return fmt.Errorf("include[%d].exclude: %w", index, err)
+		}
+	}
+	return nil
+}
+
+// parseIncludeScopeOptions parses the vars and as fields of an include entry, setting the template
+// values of the included files.
+func parseIncludeScopeOptions(configMap map[string]any, index int, inc *includeConfig) error {
+	if varsValue, exists := configMap["vars"]; exists {
+		vars, ok := varsValue.(map[string]any)
+		if !ok {
+			return fmt.Errorf("include[%d].vars must be a map", index)
+		}
+		// Names follow the x- fields, with hyphens replaced by underscores for template compatibility
+		inc.Vars = make(map[string]any, len(vars))
+		for key, val := range vars {
+			inc.Vars[strings.ReplaceAll(key, "-", "_")] = val
+		}
+	}
+
+	if asValue, exists := configMap["as"]; exists {
+		as, ok := asValue.(string)
+		name := strings.ReplaceAll(as, "-", "_")
+		if !ok || !token.IsIdentifier(name) {
+			return fmt.Errorf("include[%d].as must be a name of letters, digits, hyphens and underscores", index)
+		}
+		inc.As = name
+	}
+
+	return nil
+}
+
+// parseIncludeDirOptions parses the options controlling how directories and glob patterns are walked.
+func parseIncludeDirOptions(configMap map[string]any, index int, inc *includeConfig) error {
+	if value, exists := configMap["skip_hidden"]; exists {
+		skipHidden, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("include[%d].skip_hidden must be a boolean", index)
+		}
+		inc.SkipHidden = skipHidden
+	}
+
+	if value, exists := configMap["max_depth"]; exists {
+		maxDepth, ok := value.(int)
+		if !ok || maxDepth < 1 {
+			return fmt.Errorf("include[%d].max_depth must be a positive integer", index)
+		}
+		inc.MaxDepth = maxDepth
+	}
+
+	if value, exists := configMap["sort"]; exists {
+		order, ok := value.(string)
+		if !ok || (order != sortLexical && order != sortNatural) {
+			return fmt.Errorf("include[%d].sort must be %q or %q", index, sortLexical, sortNatural)
+		}
+		inc.Sort = order
+	}
+
+	return nil
+}
+
+// parseIncludeGitOptions parses the options reading the paths of an include from a git repository.
+func parseIncludeGitOptions(configMap map[string]any, index int, inc *includeConfig) error {
+	refValue, hasRef := configMap["ref"]
+	gitValue, exists := configMap["git"]
+	if !exists {
+		if hasRef {
+			return fmt.Errorf("include[%d].ref requires a git repository", index)
+		}
+		return nil
+	}
+
+	repo, ok := gitValue.(string)
+	if !ok || repo == "" {
+		return fmt.Errorf("include[%d].git must be a repository path", index)
+	}
+	if isRemoteInclude(repo) || inc.SHA256 != "" {
+		return fmt.Errorf("include[%d].git must be a local repository", index)
+	}
+	inc.Git = repo
+
+	inc.Ref = "HEAD"
+	if hasRef {
+		ref, ok := refValue.(string)
+		if !ok || ref == "" {
+			return fmt.Errorf("include[%d].ref must be a branch, tag or commit", index)
+		}
+		inc.Ref = ref
 	}
 
-	switch p := pathValue.(type) {
+	return nil
+}
+
+// parseIncludeStringList parses an include field that may be a string or a list of strings.
+func parseIncludeStringList(value any, field string, index int) ([]string, error) {
+	switch v := value.(type) {
 	case string:
-		inc.Path = []string{p}
+		return []string{v}, nil
 	case []any:
-		inc.Path = make([]string, len(p))
-		for j, pathItem := range p {
-			pathStr, ok := pathItem.(string)
+		list := make([]string, len(v))
+		for j, item := range v {
+			str, ok := item.(string)
 			if !ok {
-				return includeConfig{}, fmt.Errorf("include[%d].path[%d] must be a string", index, j)
+				return nil, fmt.Errorf("include[%d].%s[%d] must be a string", index, field, j)
 			}
-			inc.Path[j] = pathStr
+			list[j] = str
 		}
+		return list, nil
 	default:
-		return includeConfig{}, fmt.Errorf("include[%d].path must be a string or list of strings", index)
+		return nil, fmt.Errorf("include[%d].%s must be a string or list of strings", index, field)
 	}
-
-	return inc, nil
 }
 
 // mergeConfig deep merges source into target.
 // For conflicting keys, source takes precedence.
+// Values tagged !reset drop the target value and values tagged !override replace it.
 func mergeConfig(target, source map[string]any) error {
+	return (&merger{}).mergeMap(target, source, "", "")
+}
+
+// merger deep merges a source into a config, recording in prov where the merged values were defined.
+type merger struct {
+	prov      *Provenance
+	positions map[string]Position // positions of the source values by JSON pointer
+}
+
+// mergeMap merges the source map at sourcePointer into the target map at targetPointer.
+func (m *merger) mergeMap(target, source map[string]any, targetPointer, sourcePointer string) error {
 	for key, sourceValue := range source {
+		keyTarget, keySource := joinPointer(targetPointer, key), joinPointer(sourcePointer, key)
 		targetValue, exists := target[key]
+
+		switch v := sourceValue.(type) {
+		case mergeReset:
+			delete(target, key)
+			m.prov.remove(keyTarget)
+			continue
+		case mergeOverride:
+			m.prov.remove(keyTarget)
+			target[key] = m.place(v.value, keyTarget, keySource)
+			continue
+		}
+
 		if !exists {
-			target[key] = sourceValue
+			target[key] = m.place(sourceValue, keyTarget, keySource)
 			continue
 		}
 
-		if err := mergeValue(target, key, targetValue, sourceValue); err != nil {
+		if err := m.mergeValue(target, key, keyTarget, keySource, targetValue, sourceValue); err != nil {
 			return err
 		}
 	}
@@ -233,13 +779,13 @@ func mergeConfig(target, source map[string]any) error {
 }
 
 // mergeValue merges a single value into the target map at the specified key.
-func mergeValue(target map[string]any, key string, targetValue, sourceValue any) error {
+func (m *merger) mergeValue(target map[string]any, key, targetPointer, sourcePointer string, targetValue, sourceValue any) error {
 	// If both are maps, merge recursively
 	sourceMap, sourceIsMap := sourceValue.(map[string]any)
 	targetMap, targetIsMap := targetValue.(map[string]any)
 
 	if sourceIsMap && targetIsMap {
-		return mergeConfig(targetMap, sourceMap)
+		return m.mergeMap(targetMap, sourceMap, targetPointer, sourcePointer)
 	}
 
 	// If both are arrays, concatenate them
@@ -247,14 +793,62 @@ func mergeValue(target map[string]any, key string, targetValue, sourceValue any)
 	targetSlice, targetIsSlice := targetValue.([]any)
 
 	if sourceIsSlice && targetIsSlice {
-		target[key] = append(targetSlice, sourceSlice...)
+		for i, item := range sourceSlice {
+			itemTarget := joinPointer(targetPointer, strconv.Itoa(len(targetSlice)))
+			itemSource := joinPointer(sourcePointer, strconv.Itoa(i))
+			if _, ok := item.(mergeReset); ok {
+				continue
+			}
+			targetSlice = append(targetSlice, m.place(item, itemTarget, itemSource))
+		}
+		target[key] = targetSlice
 		return nil
 	}
 
 	// Check for conflicts (different types or non-map values)
+	sourceValue = stripMergeTags(sourceValue)
 	if !reflect.DeepEqual(sourceValue, targetValue) {
-		return fmt.Errorf("conflict at key %q: cannot merge %T with %T", key, targetValue, sourceValue)
+		return fmt.Errorf("conflict at key %q: cannot merge %T%s with %T%s (use !override to replace it)",
+			key, targetValue, m.prov.describe(targetPointer), sourceValue, m.describeSource(sourcePointer))
 	}
 
 	return nil
 }
+
+// place strips the merge tags from a source value placed in the target and records its positions.
+func (m *merger) place(value any, targetPointer, sourcePointer string) any {
+	value = stripMergeTags(value)
+	m.record(value, targetPointer, sourcePointer)
+	return value
+}
+
+// record copies the positions of a source value and everything below it to the provenance.
+func (m *merger) record(value any, targetPointer, sourcePointer string) {
+	if m.prov == nil {
+		return
+	}
+
+	if pos, ok := m.positions[sourcePointer]; ok {
+		m.prov.set(targetPointer, pos)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for key, inner := range v {
+			m.record(inner, joinPointer(targetPointer, key), joinPointer(sourcePointer, key))
+		}
+	case []any:
+		for i, inner := range v {
+			index := strconv.Itoa(i)
+			m.record(inner, joinPointer(targetPointer, index), joinPointer(sourcePointer, index))
+		}
+	}
+}
+
+// describeSource returns a " (file:line:column)" suffix for error messages about a source value.
+func (m *merger) describeSource(sourcePointer string) string {
+	if pos, ok := m.positions[sourcePointer]; ok {
+		return " (" + pos.String() + ")"
+	}
+	return ""
+}
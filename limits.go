@@ -0,0 +1,118 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkPath returns an error if the file at path may not be included under the settings.
+func (l *includeLoader) checkPath(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve include path %s: %w", path, err)
+	}
+
+	if l.root != "" && !isWithin(l.root, abs) {
+		return fmt.Errorf("include %s is outside the include root %s", path, l.settings.includeRoot)
+	}
+
+	if !l.settings.followSymlinks {
+		if err := l.checkSymlinks(path, abs); err != nil {
+			return err
+		}
+	}
+
+	if l.root == "" {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil && !isWithin(l.resolvedRoot, resolved) {
+		return fmt.Errorf("include %s resolves to %s outside the include root %s", path, resolved, l.settings.includeRoot)
+	}
+	return nil
+}
+
+// checkSymlinks returns an error if the include at path, with the absolute path abs, is a symlink.
+// Under an include root, the directories between the root and the include may not be symlinks either.
+func (l *includeLoader) checkSymlinks(path, abs string) error {
+	dir := filepath.Dir(abs)
+	if l.root != "" {
+		dir = l.root
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve include path %s: %w", path, err)
+	}
+
+	current := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, name)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// Missing files are reported when they are read
+			return nil
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+		if current == abs {
+			return fmt.Errorf("include %s is a symlink, which is not allowed", path)
+		}
+		return fmt.Errorf("include %s is in the symlinked directory %s, which is not allowed", path, current)
+	}
+	return nil
+}
+
+// symlinkOutsideRoot reports whether the walked entry d at path is a symlink to a directory
+// outside the include root, which is skipped rather than listed.
+func (l *includeLoader) symlinkOutsideRoot(path string, d fs.DirEntry) bool {
+	if l.root == "" || d.Type()&fs.ModeSymlink == 0 {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(resolved)
+	return err == nil && info.IsDir() && !isWithin(l.resolvedRoot, resolved)
+}
+
+// checkDepth returns an error if including a file with the given chain of including files
+// exceeds the maximum include depth. The chain starts with the root file.
+func (l *includeLoader) checkDepth(included []string, path string) error {
+	depth := len(included)
+	if l.settings.maxIncludeDepth > 0 && depth > l.settings.maxIncludeDepth {
+		return fmt.Errorf("include depth limit of %d exceeded by %s", l.settings.maxIncludeDepth, path)
+	}
+	return nil
+}
+
+// sizeLimitError returns the error for an include exceeding the maximum include size.
+func (l *includeLoader) sizeLimitError(path string) error {
+	return fmt.Errorf("include size limit of %d bytes exceeded by %s", l.settings.maxIncludeSize, path)
+}
+
+// account adds an included file of the given size to the totals and checks them against the limits.
+func (l *includeLoader) account(path string, size int) error {
+	l.files++
+	l.size += size
+
+	if l.settings.maxIncludeFiles > 0 && l.files > l.settings.maxIncludeFiles {
+		return fmt.Errorf("include file limit of %d exceeded by %s", l.settings.maxIncludeFiles, path)
+	}
+	if l.settings.maxIncludeSize > 0 && l.size > l.settings.maxIncludeSize {
+		return l.sizeLimitError(path)
+	}
+	return nil
+}
+
+// isWithin reports whether the absolute path is dir or inside it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
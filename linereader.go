@@ -49,6 +49,21 @@ func extractAllMatchingTopLevelSections(body []byte, pattern *regexp.Regexp) (se
 	return sectionsBuffer.Bytes(), remainingBuffer.Bytes()
 }
 
+// blankOtherSections is like extractAllMatchingTopLevelSections, but replaces the lines outside the
+// matching sections by empty lines instead of removing them, so the sections keep their line numbers.
+func blankOtherSections(body []byte, pattern *regexp.Regexp) []byte {
+	var sectionsBuffer, remainingBuffer bytes.Buffer
+	inSection := false
+	for _, line := range strings.SplitAfter(string(body), "\n") {
+		n := sectionsBuffer.Len()
+		inSection = processLine(line, pattern, &sectionsBuffer, &remainingBuffer, inSection)
+		if sectionsBuffer.Len() == n && strings.HasSuffix(line, "\n") {
+			sectionsBuffer.WriteString("\n")
+		}
+	}
+	return sectionsBuffer.Bytes()
+}
+
 // processLine processes a single line for extractAllMatchingTopLevelSections.
 // Returns the updated inSection state.
 func processLine(line string, pattern *regexp.Regexp, sectionsBuffer, remainingBuffer *bytes.Buffer, inSection bool) bool {
@@ -75,3 +90,15 @@ func handleSectionLine(line string, sectionsBuffer, remainingBuffer *bytes.Buffe
 	sectionsBuffer.WriteString(line)
 	return true
 }
+
+// sectionLineOffset returns the number of lines before the first top-level section matching pattern.
+// Adding it to a line in the extracted section gives the line in the body, as long as the
+// section is the only one matching pattern.
+func sectionLineOffset(body []byte, pattern *regexp.Regexp) int {
+	for i, line := range strings.Split(string(body), "\n") {
+		if pattern.MatchString(line) {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,203 @@
+package caddyyaml
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// memFS is a read-only filesystem held in memory, used to read includes from git trees and archives.
+// File contents are loaded on first use.
+type memFS struct {
+	entries map[string]*memEntry
+}
+
+// memEntry is a file or directory in a memFS. It is its own fs.FileInfo and fs.DirEntry.
+type memEntry struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+
+	// load loads the content of a file, which is kept in data once loaded
+	load func() ([]byte, error)
+	data []byte
+
+	// children are the sorted names of the entries of a directory
+	children []string
+}
+
+// newMemFS creates an empty memFS.
+func newMemFS() *memFS {
+	return &memFS{entries: map[string]*memEntry{".": {name: ".", dir: true}}}
+}
+
+// addFile adds a file of the given size, creating its parent directories.
+func (m *memFS) addFile(name string, size int64, modTime time.Time, load func() ([]byte, error)) {
+	m.add(&memEntry{name: name, size: size, modTime: modTime, load: load})
+}
+
+// addDir adds a directory, creating its parent directories.
+func (m *memFS) addDir(name string) {
+	if _, ok := m.entries[name]; !ok {
+		m.add(&memEntry{name: name, dir: true})
+	}
+}
+
+func (m *memFS) add(e *memEntry) {
+	m.entries[e.name] = e
+	dir := path.Dir(e.name)
+	m.addDir(dir)
+	parent := m.entries[dir]
+	base := path.Base(e.name)
+	if i, found := slices.BinarySearch(parent.children, base); !found {
+		parent.children = slices.Insert(parent.children, i, base)
+	}
+}
+
+// lookup returns the entry of name, or an fs.PathError for op.
+func (m *memFS) lookup(op, name string) (*memEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// Open implements fs.FS.
+func (m *memFS) Open(name string) (fs.File, error) {
+	e, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir {
+		return &memDir{fsys: m, entry: e}, nil
+	}
+	data, err := e.content()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &memFile{entry: e, Reader: bytes.NewReader(data)}, nil
+}
+
+// Stat implements fs.StatFS.
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	return m.lookup("stat", name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return m.dirEntries(e), nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	e, err := m.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := e.content()
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return slices.Clone(data), nil
+}
+
+func (m *memFS) dirEntries(e *memEntry) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(e.children))
+	for _, child := range e.children {
+		entries = append(entries, m.entries[path.Join(e.name, child)])
+	}
+	return entries
+}
+
+// content returns the content of a file, loading it on first use.
+func (e *memEntry) content() ([]byte, error) {
+	if e.data == nil && e.load != nil {
+		data, err := e.load()
+		if err != nil {
+			return nil, err
+		}
+		e.data, e.load = data, nil
+	}
+	return e.data, nil
+}
+
+func (e *memEntry) Name() string               { return path.Base(e.name) }
+func (e *memEntry) Size() int64                { return e.size }
+func (e *memEntry) ModTime() time.Time         { return e.modTime }
+func (e *memEntry) IsDir() bool                { return e.dir }
+func (e *memEntry) Sys() any                   { return nil }
+func (e *memEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *memEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+func (e *memEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// memFile is an open file of a memFS.
+type memFile struct {
+	entry *memEntry
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memDir is an open directory of a memFS.
+type memDir struct {
+	fsys    *memFS
+	entry   *memEntry
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.fsys.dirEntries(d.entry)
+	}
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+// cleanMemPath converts a slash-separated path from an archive or tree listing to a memFS name.
+func cleanMemPath(p string) string {
+	return path.Clean(strings.Trim(p, "/"))
+}
@@ -0,0 +1,184 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Position is the location in a source file a config value was defined at.
+// Lines refer to the file after templating, which matches the original file
+// unless template directives add or remove lines before the value.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String returns the position formatted as file:line:column, or the file alone if the line is unknown.
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Provenance records the position every value of an adapted config was defined at,
+// keyed by the JSON pointer (RFC 6901) of the value in the adapted config, e.g.
+// "/apps/http/servers/srv0/listen/0".
+//
+// Pass a *Provenance in the adapter options under ProvenanceOptionName to have it filled in.
+type Provenance struct {
+	positions map[string]Position
+}
+
+// NewProvenance creates an empty provenance.
+func NewProvenance() *Provenance {
+	return &Provenance{positions: make(map[string]Position)}
+}
+
+// Lookup returns the position the value at the JSON pointer was defined at.
+// If the value itself has no recorded position, such as a value merged in through
+// a YAML merge key, the position of its closest recorded parent is returned.
+func (p *Provenance) Lookup(pointer string) (Position, bool) {
+	for {
+		if pos, ok := p.positions[pointer]; ok {
+			return pos, true
+		}
+		i := strings.LastIndex(pointer, "/")
+		if i < 0 {
+			return Position{}, false
+		}
+		pointer = pointer[:i]
+	}
+}
+
+// Paths returns the JSON pointers of all recorded values in sorted order.
+func (p *Provenance) Paths() []string {
+	return slices.Sorted(maps.Keys(p.positions))
+}
+
+// set records the position of the value at pointer.
+func (p *Provenance) set(pointer string, pos Position) {
+	if p != nil {
+		p.positions[pointer] = pos
+	}
+}
+
+// remove drops the positions of the value at pointer and everything below it.
+func (p *Provenance) remove(pointer string) {
+	if p == nil {
+		return
+	}
+	for key := range p.positions {
+		if key == pointer || strings.HasPrefix(key, pointer+"/") {
+			delete(p.positions, key)
+		}
+	}
+}
+
+// reindex moves the positions of the items of the list at pointer to their new indices.
+func (p *Provenance) reindex(pointer string, newIndex map[int]int) {
+	if p == nil {
+		return
+	}
+
+	moved := make(map[string]Position)
+	prefix := pointer + "/"
+	for key, pos := range p.positions {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		indexStr, below, _ := strings.Cut(rest, "/")
+		index, err := strconv.Atoi(indexStr)
+		if err != nil {
+			continue
+		}
+		to, ok := newIndex[index]
+		if !ok || to == index {
+			continue
+		}
+		delete(p.positions, key)
+		newKey := prefix + strconv.Itoa(to)
+		if below != "" {
+			newKey += "/" + below
+		}
+		moved[newKey] = pos
+	}
+	maps.Copy(p.positions, moved)
+}
+
+// describe returns a " (file:line:column)" suffix for error messages about the value at pointer,
+// or an empty string if its position is unknown.
+func (p *Provenance) describe(pointer string) string {
+	if p == nil {
+		return ""
+	}
+	if pos, ok := p.Lookup(pointer); ok {
+		return " (" + pos.String() + ")"
+	}
+	return ""
+}
+
+// joinPointer appends a key or index to a JSON pointer, escaping it as required by RFC 6901.
+func joinPointer(pointer, key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	key = strings.ReplaceAll(key, "/", "~1")
+	return pointer + "/" + key
+}
+
+// nodePositions records the positions of the values below n, keyed by their JSON pointer
+// relative to pointer. Values in mappings are positioned at their key.
+// Nodes spliced in from other files are positioned in the file recorded for them in files.
+func nodePositions(file string, n *yaml.Node, pointer string, positions map[string]Position, files map[*yaml.Node]string) {
+	if f, ok := files[n]; ok {
+		file = f
+	}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, child := range n.Content {
+			nodePositions(file, child, pointer, positions, files)
+		}
+	case yaml.MappingNode:
+		mappingPositions(file, n, pointer, positions, files)
+	case yaml.SequenceNode:
+		sequencePositions(file, n, pointer, positions, files)
+	}
+}
+
+// mappingPositions records the positions of the values of the mapping n, positioned at their key.
+func mappingPositions(file string, n *yaml.Node, pointer string, positions map[string]Position, files map[*yaml.Node]string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if key.Value == "<<" {
+			// Values merged from an anchor keep the position of the including mapping
+			continue
+		}
+		keyFile := file
+		if f, ok := files[key]; ok {
+			keyFile = f
+		}
+		keyPointer := joinPointer(pointer, key.Value)
+		positions[keyPointer] = Position{File: keyFile, Line: key.Line, Column: key.Column}
+		nodePositions(keyFile, value, keyPointer, positions, files)
+	}
+}
+
+// sequencePositions records the positions of the items of the sequence n.
+func sequencePositions(file string, n *yaml.Node, pointer string, positions map[string]Position, files map[*yaml.Node]string) {
+	for i, item := range n.Content {
+		itemFile := file
+		if f, ok := files[item]; ok {
+			itemFile = f
+		}
+		itemPointer := joinPointer(pointer, strconv.Itoa(i))
+		positions[itemPointer] = Position{File: itemFile, Line: item.Line, Column: item.Column}
+		nodePositions(itemFile, item, itemPointer, positions, files)
+	}
+}
@@ -0,0 +1,180 @@
+package caddyyaml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// maxRemoteIncludeSize is the maximum size of a remote include file.
+const maxRemoteIncludeSize = 10 << 20
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// errChecksumMismatch is returned for remote includes not matching their pinned checksum.
+// It is fatal even for optional includes, as the content was tampered with or the pin is stale.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// defaultIncludeCacheDir returns the directory remote includes are cached in by default.
+func defaultIncludeCacheDir() string {
+	return filepath.Join(caddy.AppDataDir(), "yaml", "includes")
+}
+
+// defaultHTTPClient is the client used to fetch remote includes unless one is set in the options.
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// isRemoteInclude reports whether the include path is an HTTPS URL.
+func isRemoteInclude(path string) bool {
+	return strings.HasPrefix(path, "https://")
+}
+
+// resolveRemoteInclude resolves an include path found in the remote file from.
+// Relative paths are resolved against the URL of the including file; local paths are rejected.
+func resolveRemoteInclude(path, from string) (string, error) {
+	if isRemoteInclude(path) {
+		return path, nil
+	}
+
+	ref, err := url.Parse(path)
+	if err != nil || ref.IsAbs() || filepath.IsAbs(path) {
+		return "", fmt.Errorf("remote include %s cannot include local path %s", from, path)
+	}
+
+	base, err := url.Parse(from)
+	if err != nil {
+		return "", fmt.Errorf("invalid remote include %s: %w", from, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// parseIncludeRemoteOptions parses the sha256 field of an include entry, pinning a remote include.
+func parseIncludeRemoteOptions(configMap map[string]any, index int, inc *includeConfig) error {
+	sumValue, exists := configMap["sha256"]
+	if !exists {
+		return nil
+	}
+
+	sum, ok := sumValue.(string)
+	if !ok || !sha256Regexp.MatchString(strings.ToLower(sum)) {
+		return fmt.Errorf("include[%d].sha256 must be a hex encoded sha256 checksum", index)
+	}
+	if len(inc.Path) != 1 {
+		return fmt.Errorf("include[%d].sha256 requires a single path", index)
+	}
+	inc.SHA256 = strings.ToLower(sum)
+	return nil
+}
+
+// processIncludeRemote fetches a remote include, verifies it against the pinned checksum
+// and returns its sources.
+func (l *includeLoader) processIncludeRemote(rawURL, from string, inc includeConfig, included []string) ([]source, error) {
+	if inc.SHA256 == "" {
+		return nil, fmt.Errorf("remote include %s requires a sha256 checksum", rawURL)
+	}
+
+	// Check for circular includes
+	if slices.Contains(included, rawURL) {
+		return nil, fmt.Errorf("circular include detected: %s", rawURL)
+	}
+	if err := l.checkDepth(included, rawURL); err != nil {
+		return nil, err
+	}
+
+	content, err := l.fetchRemote(rawURL, inc.SHA256)
+	if err != nil {
+		if !inc.Required && !errors.Is(err, errChecksumMismatch) {
+			l.wc.AddFile(from, inc.line, "include", fmt.Sprintf("optional include %s could not be fetched, skipping: %v", rawURL, err))
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := l.account(rawURL, len(content)); err != nil {
+		return nil, err
+	}
+
+	newIncluded := append(slices.Clip(included), rawURL)
+	return l.loadSource(content, rawURL, inc.scope, newIncluded)
+}
+
+// fetchRemote returns the content of a remote include with the given sha256 checksum.
+// Content is served from the cache directory when present, so reloads work offline once fetched.
+func (l *includeLoader) fetchRemote(rawURL, sum string) ([]byte, error) {
+	cachePath := ""
+	if l.cacheDir != "" {
+		cachePath = filepath.Join(l.cacheDir, sum)
+		if content, err := os.ReadFile(cachePath); err == nil && sha256Hex(content) == sum {
+			return content, nil
+		}
+	}
+
+	resp, err := l.client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote include %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote include %s: unexpected status %s", rawURL, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteIncludeSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote include %s: %w", rawURL, err)
+	}
+	if len(content) > maxRemoteIncludeSize {
+		return nil, fmt.Errorf("remote include %s exceeds %d bytes", rawURL, maxRemoteIncludeSize)
+	}
+
+	if actual := sha256Hex(content); actual != sum {
+		return nil, fmt.Errorf("%w for remote include %s: expected sha256 %s, got %s", errChecksumMismatch, rawURL, sum, actual)
+	}
+
+	if cachePath != "" {
+		if err := writeCacheFile(cachePath, content); err != nil {
+			l.wc.Add(-1, "include", fmt.Sprintf("failed to cache remote include %s: %v", rawURL, err))
+		}
+	}
+
+	return content, nil
+}
+
+// writeCacheFile atomically writes content to the cache file at path.
+func writeCacheFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// sha256Hex returns the hex encoded sha256 checksum of content.
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,103 @@
+package caddyyaml
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// routePriorityKey is the key ordering items of merged routes lists.
+const routePriorityKey = "x-priority"
+
+// sortRoutes stable sorts every routes list in the config by the x-priority of its items,
+// highest priority first, and removes the x-priority keys. Items without a priority default to 0.
+// Lists without any x-priority keep the order they were merged in. The provenance of moved items is updated.
+func sortRoutes(value any, pointer string, prov *Provenance) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, inner := range v {
+			if err := sortRoutesField(key, inner, joinPointer(pointer, key), prov); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, inner := range v {
+			if err := sortRoutes(inner, joinPointer(pointer, strconv.Itoa(i)), prov); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortRoutesField sorts the value of the field key, first sorting it as a routes list if it is one.
+func sortRoutesField(key string, value any, pointer string, prov *Provenance) error {
+	if routes, ok := value.([]any); ok && key == "routes" {
+		if err := sortRouteList(routes, pointer, prov); err != nil {
+			return err
+		}
+	}
+	return sortRoutes(value, pointer, prov)
+}
+
+// sortRouteList sorts a single routes list in place by the priority of its items.
+func sortRouteList(routes []any, pointer string, prov *Provenance) error {
+	type prioritizedRoute struct {
+		route    any
+		priority float64
+		index    int
+	}
+
+	prioritized := make([]prioritizedRoute, len(routes))
+	hasPriority := false
+	for i, route := range routes {
+		prioritized[i].route = route
+		prioritized[i].index = i
+		routeMap, ok := route.(map[string]any)
+		if !ok {
+			continue
+		}
+		value, exists := routeMap[routePriorityKey]
+		if !exists {
+			continue
+		}
+
+		priority, ok := numberValue(value)
+		if !ok {
+			priorityPointer := joinPointer(joinPointer(pointer, strconv.Itoa(i)), routePriorityKey)
+			return fmt.Errorf("%s must be a number, got %T%s", priorityPointer, value, prov.describe(priorityPointer))
+		}
+		delete(routeMap, routePriorityKey)
+		prov.remove(joinPointer(joinPointer(pointer, strconv.Itoa(i)), routePriorityKey))
+		prioritized[i].priority = priority
+		hasPriority = true
+	}
+
+	if !hasPriority {
+		return nil
+	}
+
+	slices.SortStableFunc(prioritized, func(a, b prioritizedRoute) int {
+		return cmp.Compare(b.priority, a.priority)
+	})
+	newIndex := make(map[int]int, len(prioritized))
+	for i, p := range prioritized {
+		routes[i] = p.route
+		newIndex[p.index] = i
+	}
+	prov.reindex(pointer, newIndex)
+
+	return nil
+}
+
+// numberValue converts a decoded YAML number to a float64.
+func numberValue(value any) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,180 @@
+package caddyyaml
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var settingsLineRegexp = regexp.MustCompile(`^adapter(\s*)\:`)
+
+// settings configure how the adapter processes a config. They are read from the adapter options
+// and from the top-level adapter section of the root file, with adapter options taking precedence.
+type settings struct {
+	// includeRoot confines includes to a directory, if set
+	includeRoot string
+	// followSymlinks allows including symlinks
+	followSymlinks bool
+	// maxIncludeDepth limits the nesting of includes, if positive
+	maxIncludeDepth int
+	// maxIncludeFiles limits the number of files read, if positive
+	maxIncludeFiles int
+	// maxIncludeSize limits the total number of bytes read, if positive
+	maxIncludeSize int
+	// envVariables declares the environment variables as $VAR template variables
+	envVariables bool
+	// strict fails templates referencing missing or null values
+	strict bool
+	// delims are the template delimiters of files without a delims header
+	delims delimiters
+	// allowFuncs lists the only template functions available, if not nil
+	allowFuncs []string
+	// denyFuncs lists template functions that are unavailable
+	denyFuncs []string
+	// reproducible makes non-deterministic template functions unavailable
+	reproducible bool
+}
+
+// settingSpec describes a setting that can be set through an adapter option and the adapter section.
+type settingSpec struct {
+	option string
+	key    string
+	apply  func(s *settings, value any) bool
+}
+
+// settingSpecs lists all settings. apply returns false if the value has the wrong type.
+var settingSpecs = []settingSpec{
+	{IncludeRootOptionName, "include_root", func(s *settings, value any) bool {
+		v, ok := value.(string)
+		s.includeRoot = v
+		return ok
+	}},
+	{FollowSymlinksOptionName, "follow_symlinks", func(s *settings, value any) bool {
+		v, ok := value.(bool)
+		s.followSymlinks = v
+		return ok
+	}},
+	{MaxIncludeDepthOptionName, "max_include_depth", func(s *settings, value any) bool {
+		v, ok := value.(int)
+		s.maxIncludeDepth = v
+		return ok && v >= 0
+	}},
+	{MaxIncludeFilesOptionName, "max_include_files", func(s *settings, value any) bool {
+		v, ok := value.(int)
+		s.maxIncludeFiles = v
+		return ok && v >= 0
+	}},
+	{MaxIncludeSizeOptionName, "max_include_size", func(s *settings, value any) bool {
+		v, ok := value.(int)
+		s.maxIncludeSize = v
+		return ok && v >= 0
+	}},
+	{EnvVariablesOptionName, "env_variables", func(s *settings, value any) bool {
+		v, ok := value.(bool)
+		s.envVariables = v
+		return ok
+	}},
+	{StrictOptionName, "strict", func(s *settings, value any) bool {
+		v, ok := value.(bool)
+		s.strict = v
+		return ok
+	}},
+	{DelimitersOptionName, "delimiters", func(s *settings, value any) bool {
+		d, ok := parseDelimiters(value)
+		s.delims = d
+		return ok
+	}},
+	{AllowFuncsOptionName, "allow_funcs", func(s *settings, value any) bool {
+		names, ok := parseFuncNames(value)
+		s.allowFuncs = names
+		return ok
+	}},
+	{DenyFuncsOptionName, "deny_funcs", func(s *settings, value any) bool {
+		names, ok := parseFuncNames(value)
+		s.denyFuncs = names
+		return ok
+	}},
+	{ReproducibleOptionName, "reproducible", func(s *settings, value any) bool {
+		v, ok := value.(bool)
+		s.reproducible = v
+		return ok
+	}},
+}
+
+// loadSettings reads the settings from the adapter options and the adapter section of the root file.
+// A relative include root in the adapter section is resolved from the directory of the root file.
+func loadSettings(body []byte, filename string, options map[string]any) (settings, error) {
+	s := settings{followSymlinks: true, envVariables: true, delims: defaultDelimiters}
+
+	section, err := parseSettingsSection(body)
+	if err != nil {
+		return settings{}, err
+	}
+
+	for _, spec := range settingSpecs {
+		if err := spec.load(&s, options, section, filename); err != nil {
+			return settings{}, err
+		}
+	}
+
+	for key := range section {
+		if !knownSetting(key) {
+			return settings{}, fmt.Errorf("unknown adapter setting %q", key)
+		}
+	}
+
+	return s, nil
+}
+
+// load applies the setting from the adapter options, or else from the adapter section of the root file.
+func (spec settingSpec) load(s *settings, options, section map[string]any, filename string) error {
+	if value, ok := options[spec.option]; ok {
+		if !spec.apply(s, value) {
+			return fmt.Errorf("invalid value for option %s: %v", spec.option, value)
+		}
+		return nil
+	}
+
+	value, ok := section[spec.key]
+	if !ok {
+		return nil
+	}
+	if !spec.apply(s, value) {
+		return fmt.Errorf("invalid value for adapter.%s: %v", spec.key, value)
+	}
+	if spec.key == "include_root" && s.includeRoot != "" && !filepath.IsAbs(s.includeRoot) {
+		s.includeRoot = filepath.Join(filepath.Dir(filename), s.includeRoot)
+	}
+	return nil
+}
+
+// parseSettingsSection extracts the top-level adapter section from the raw body and parses it.
+func parseSettingsSection(body []byte) (map[string]any, error) {
+	section, _ := extractAllMatchingTopLevelSections(body, settingsLineRegexp)
+	if len(section) == 0 {
+		return nil, nil
+	}
+
+	var config map[string]any
+	if err := yaml.Unmarshal(section, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse adapter settings: %w", err)
+	}
+
+	settingsValue, ok := config["adapter"].(map[string]any)
+	if !ok && config["adapter"] != nil {
+		return nil, fmt.Errorf("adapter settings must be a map, got %T", config["adapter"])
+	}
+	return settingsValue, nil
+}
+
+// knownSetting reports whether key is the key of a setting in the adapter section.
+func knownSetting(key string) bool {
+	for _, spec := range settingSpecs {
+		if spec.key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package caddyyaml
+
+import (
+	"cmp"
+	"path/filepath"
+	"strings"
+)
+
+// Include sort orders.
+const (
+	sortLexical = "lexical"
+	sortNatural = "natural"
+)
+
+// compareNatural compares two names treating runs of digits as numbers, so "2-blog" sorts before "10-api".
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			var numA, numB string
+			numA, a = cutDigits(a)
+			numB, b = cutDigits(b)
+			if c := compareNumbers(numA, numB); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// comparePaths compares two paths segment by segment using the given sort order,
+// which matches the order directories are walked in.
+func comparePaths(a, b, order string) int {
+	compare := strings.Compare
+	if order == sortNatural {
+		compare = compareNatural
+	}
+
+	segmentsA := strings.Split(filepath.ToSlash(a), "/")
+	segmentsB := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(segmentsA) && i < len(segmentsB); i++ {
+		if c := compare(segmentsA[i], segmentsB[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(segmentsA), len(segmentsB))
+}
+
+// compareNumbers compares two digit strings by numeric value, falling back to the
+// number of leading zeros so that equal values still sort deterministically.
+func compareNumbers(a, b string) int {
+	trimmedA := strings.TrimLeft(a, "0")
+	trimmedB := strings.TrimLeft(b, "0")
+	if c := cmp.Compare(len(trimmedA), len(trimmedB)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(trimmedA, trimmedB); c != 0 {
+		return c
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// cutDigits splits s after its leading run of digits.
+func cutDigits(s string) (digits, rest string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// isHidden reports whether a file or directory name is hidden, such as dotfiles and editor lock files.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,166 @@
+package caddyyaml
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge tags follow the Docker Compose merge semantics:
+// https://docs.docker.com/reference/compose-file/merge/#reset-value
+const (
+	resetTag    = "!reset"
+	overrideTag = "!override"
+)
+
+// mergeTagKeyPrefix prefixes the key of the mapping a tagged node is wrapped in while decoding.
+// The NUL byte ensures it cannot clash with keys written in a config file.
+const mergeTagKeyPrefix = "\x00"
+
+// mergeReset marks a key whose previously merged value is dropped.
+type mergeReset struct{}
+
+// mergeOverride marks a value replacing the previously merged value instead of being merged with it.
+type mergeOverride struct {
+	value any
+}
+
+// decodeSource parses a templated source body into a config map, resolving the custom tags it contains.
+// It also returns the positions of the values in the source, keyed by JSON pointer.
+func decodeSource(src source, body []byte, r *tagResolver) (map[string]any, map[string]Position, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(body, &root); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.resolve(&root, src.path, src.included); err != nil {
+		return nil, nil, err
+	}
+
+	positions := make(map[string]Position)
+	nodePositions(src.path, &root, "", positions, r.files)
+
+	config, err := decodeNode(&root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, positions, nil
+}
+
+// decodeNode decodes a mapping node into a config map, converting the values tagged with a merge tag
+// into merge markers. The node is modified in the process.
+func decodeNode(n *yaml.Node) (map[string]any, error) {
+	wrapMergeTags(n)
+
+	var config map[string]any
+	if err := n.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	for key, value := range config {
+		config[key] = unwrapMergeTags(value)
+	}
+	return config, nil
+}
+
+// wrapMergeTags replaces nodes tagged with a merge tag by a single key mapping holding the untagged node,
+// so the tag survives decoding into plain values.
+func wrapMergeTags(n *yaml.Node) {
+	for _, child := range n.Content {
+		wrapMergeTags(child)
+	}
+
+	if n.Tag != resetTag && n.Tag != overrideTag {
+		return
+	}
+
+	tag, anchor := n.Tag, n.Anchor
+	inner := *n
+	inner.Tag = ""
+	inner.Anchor = ""
+	*n = yaml.Node{
+		Kind:   yaml.MappingNode,
+		Tag:    "!!map",
+		Anchor: anchor,
+		Line:   inner.Line,
+		Column: inner.Column,
+		Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: mergeTagKeyPrefix + tag},
+			&inner,
+		},
+	}
+}
+
+// unwrapMergeTags converts the mappings created by wrapMergeTags into merge markers.
+func unwrapMergeTags(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if marker, ok := mergeMarker(v); ok {
+			return marker
+		}
+		for key, inner := range v {
+			v[key] = unwrapMergeTags(inner)
+		}
+	case []any:
+		for i, inner := range v {
+			v[i] = unwrapMergeTags(inner)
+		}
+	}
+	return value
+}
+
+// mergeMarker returns the merge marker of a mapping created by wrapMergeTags, reporting false
+// if m is not such a mapping.
+func mergeMarker(m map[string]any) (any, bool) {
+	if len(m) != 1 {
+		return nil, false
+	}
+	for key, inner := range m {
+		tag, ok := strings.CutPrefix(key, mergeTagKeyPrefix)
+		if !ok {
+			return nil, false
+		}
+		if tag == resetTag {
+			return mergeReset{}, true
+		}
+		return mergeOverride{value: unwrapMergeTags(inner)}, true
+	}
+	return nil, false
+}
+
+// stripMergeTags removes merge markers from a value that is not merged with a previous value.
+// Reset keys are dropped and overrides are replaced by their value.
+func stripMergeTags(value any) any {
+	switch v := value.(type) {
+	case mergeOverride:
+		return stripMergeTags(v.value)
+	case map[string]any:
+		stripMergeTagsMap(v)
+	case []any:
+		return stripMergeTagsList(v)
+	}
+	return value
+}
+
+// stripMergeTagsMap removes merge markers from the values of m, dropping reset keys.
+func stripMergeTagsMap(m map[string]any) {
+	for key, inner := range m {
+		if _, ok := inner.(mergeReset); ok {
+			delete(m, key)
+			continue
+		}
+		m[key] = stripMergeTags(inner)
+	}
+}
+
+// stripMergeTagsList removes merge markers from the items of list, dropping reset items.
+func stripMergeTagsList(list []any) []any {
+	items := list[:0]
+	for _, inner := range list {
+		if _, ok := inner.(mergeReset); ok {
+			continue
+		}
+		items = append(items, stripMergeTags(inner))
+	}
+	return items
+}
@@ -2,48 +2,228 @@ package caddyyaml
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"go/token"
+	"maps"
 	"strings"
 	"text/template"
-
-	"github.com/Masterminds/sprig/v3"
 )
 
+// Default template delimiters, see delimiters.
 const (
 	openingDelim = "#{"
 	closingDelim = "}"
 )
 
-// applyTemplate processes the YAML body as a Go template with sprig functions.
-// It prepends environment variables as template variables and executes the template with the provided values.
-// Returns the processed template output or an error if template parsing or execution fails.
-func applyTemplate(body []byte, values map[string]any, env []string, wc *warningsCollector) ([]byte, error) {
-	tplBody := envVarsTemplate(env, wc) + string(body)
+// envValueName is the name of the template value holding the environment variables.
+const envValueName = "Env"
+
+// templateContext holds what templates are executed with besides their values:
+// the environment and the functions available to them.
+type templateContext struct {
+	// env holds the environment variables, available as .Env and through the env and required functions.
+	// It is empty if the env function is unavailable.
+	env map[string]string
+	// envAccess reports whether the env function is available
+	envAccess bool
+	// declarations declare the environment variables as $VAR template variables, unless disabled
+	declarations []string
+	// prelude holds the declarations wrapped in delims
+	prelude string
+	// delims are the delimiters of files without a delims header
+	delims delimiters
+	funcs  template.FuncMap
+	// strict fails templates referencing missing or null values
+	strict bool
+
+	// key identifies the context in the parse cache
+	key [sha256.Size]byte
+}
+
+// newTemplateContext creates the template context of the environment env, adding warnings to wc.
+func newTemplateContext(env []string, s settings, wc *warningsCollector) *templateContext {
+	// Templates without the env function have no access to the environment
+	envAccess := funcAvailable(s, "env")
+	if !envAccess {
+		env = nil
+	}
+
+	tc := &templateContext{env: make(map[string]string, len(env)), envAccess: envAccess, delims: s.delims, strict: s.strict}
+	for _, kv := range env {
+		key, val, _ := strings.Cut(kv, "=")
+		tc.env[key] = val
+	}
+	if s.envVariables {
+		tc.declarations = envVarsDeclarations(env, wc)
+	}
+	tc.prelude = tc.preludeFor(tc.delims)
+	tc.funcs = templateFuncs(s, tc)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%t\x00%t\x00%s\x00%t\x00%q\x00%q\x00", s.envVariables, s.strict, s.delims, s.reproducible, s.allowFuncs, s.denyFuncs)
+	for _, kv := range env {
+		fmt.Fprintf(h, "%s\x00", kv)
+	}
+	h.Sum(tc.key[:0])
+	return tc
+}
+
+// envFunc returns the value of an environment variable. Unlike the sprig function it replaces,
+// it reads the environment the config is adapted with and accepts a default for unset or empty variables.
+// In strict mode, an unset variable without a default is an error.
+func (tc *templateContext) envFunc(key string, def ...string) (string, error) {
+	if len(def) > 1 {
+		return "", fmt.Errorf("wrong number of args for env: want 1 or 2 got %d", len(def)+1)
+	}
+	val, ok := tc.env[key]
+	if len(def) == 0 {
+		if !ok && tc.strict {
+			return "", fmt.Errorf("environment variable %s is not set, pass a default as in env %q \"default\"", key, key)
+		}
+		return val, nil
+	}
+	if val == "" {
+		return def[0], nil
+	}
+	return val, nil
+}
+
+// requiredFunc fails the template with a clear message when a value is missing.
+// With one argument it returns the environment variable of that name, failing if it is unset or empty.
+// With two arguments it returns the second, failing with the first as message if it is nil or empty.
+func (tc *templateContext) requiredFunc(args ...any) (any, error) {
+	switch len(args) {
+	case 1:
+		key, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("required expects the name of an environment variable, got %T", args[0])
+		}
+		if val := tc.env[key]; val != "" {
+			return val, nil
+		}
+		return nil, fmt.Errorf("environment variable %s is required", key)
+	case 2:
+		if args[1] == nil || args[1] == "" {
+			return nil, fmt.Errorf("%v", args[0])
+		}
+		return args[1], nil
+	}
+	return nil, fmt.Errorf("wrong number of args for required: want 1 or 2 got %d", len(args))
+}
+
+// preludeFor returns the environment variable declarations wrapped in the delimiters d.
+// The declarations render to nothing and are not separated by newlines, so the lines of the
+// templated output match the lines of the source file.
+func (tc *templateContext) preludeFor(d delimiters) string {
+	if d == tc.delims && tc.prelude != "" {
+		return tc.prelude
+	}
+	var builder strings.Builder
+	for _, decl := range tc.declarations {
+		builder.WriteString(d.wrap(decl))
+	}
+	return builder.String()
+}
+
+// applyTemplate processes the YAML body as a Go template with sprig functions and the delimiters d.
+// It prepends the environment variable declarations of tc and executes the template with the
+// provided values and the environment as .Env. The name identifies the source file in template errors.
+// Returns the processed template output or an error if template parsing or execution fails,
+// located at the line and column of the body.
+func applyTemplate(name string, body []byte, values map[string]any, tc *templateContext, d delimiters) ([]byte, error) {
+	prelude := tc.preludeFor(d)
+	content, rawLine := escapeRawBlocks(stripDelimsHeader(string(body)), d)
+	if rawLine > 0 {
+		return nil, &templateError{
+			pos:     Position{File: name, Line: rawLine},
+			msg:     fmt.Sprintf("raw block is missing %s", d.wrap("endraw")),
+			context: sourceContext(body, rawLine, 0),
+		}
+	}
+	tplBody := prelude + content
+
+	// Unset environment variables in .Env render empty, unless missing keys are errors in strict mode
+	missingKey := "missingkey=zero"
+	if tc.strict {
+		missingKey = "missingkey=error"
+	}
 
-	tpl, err := template.New("yaml").
-		Funcs(sprig.TxtFuncMap()).
-		Delims(openingDelim, closingDelim).
+	tpl, err := template.New(name).
+		Funcs(tc.funcs).
+		Delims(d.open, d.close).
+		Option(missingKey).
 		Parse(tplBody)
 	if err != nil {
-		return nil, err
+		return nil, newTemplateError(name, body, prelude, err)
 	}
 
+	data := make(map[string]any, len(values)+1)
+	maps.Copy(data, values)
+	if tc.strict {
+		// Null values are treated as missing, so referencing them fails instead of rendering <no value>
+		data = withoutNulls(data).(map[string]any)
+	}
+	data[envValueName] = tc.env
+
 	var out bytes.Buffer
-	if err := tpl.Execute(&out, values); err != nil {
-		return nil, err
+	if err := tpl.Execute(&out, data); err != nil {
+		return nil, newTemplateError(name, body, prelude, err)
+	}
+
+	if tc.strict {
+		if err := checkNoValue(name, body, out.Bytes()); err != nil {
+			return nil, err
+		}
 	}
 	return out.Bytes(), nil
 }
 
-// envVarsTemplate generates template variable declarations from environment variables.
-// It filters out environment variables with invalid identifiers and adds warnings for them.
-// Returns a string containing template variable assignments for valid environment variables.
-func envVarsTemplate(env []string, wc *warningsCollector) string {
-	var builder strings.Builder
-	line := func(key, val string) string {
-		return tplWrap(fmt.Sprintf(`$%s := %q`, key, val))
+// noValue is what text/template renders for missing and nil values.
+const noValue = "<no value>"
+
+// checkNoValue fails if a template rendered <no value> where the source body has none.
+// The error points at the line of the rendered output, which matches the line of the source
+// unless template actions added or removed lines before it.
+func checkNoValue(name string, body, out []byte) error {
+	i := bytes.Index(out, []byte(noValue))
+	if i < 0 || bytes.Contains(body, []byte(noValue)) {
+		return nil
+	}
+	line := bytes.Count(out[:i], []byte("\n")) + 1
+	return &templateError{
+		pos:     Position{File: name, Line: line},
+		msg:     "template rendered " + noValue + " for a missing or null value",
+		context: sourceContext(body, line, 0),
+	}
+}
+
+// withoutNulls copies a template value, leaving out the null values of maps.
+func withoutNulls(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, inner := range v {
+			if inner != nil {
+				m[key] = withoutNulls(inner)
+			}
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, inner := range v {
+			s[i] = withoutNulls(inner)
+		}
+		return s
 	}
+	return value
+}
+
+// envVarsDeclarations generates template variable declarations from environment variables.
+// It filters out environment variables with invalid identifiers and adds warnings for them.
+// Returns the template variable assignments for valid environment variables, without delimiters.
+func envVarsDeclarations(env []string, wc *warningsCollector) []string {
+	var decls []string
 	for _, env := range env {
 		key, val, _ := strings.Cut(env, "=")
 		if !token.IsIdentifier(key) {
@@ -52,12 +232,7 @@ func envVarsTemplate(env []string, wc *warningsCollector) string {
 			}
 			continue
 		}
-		fmt.Fprintln(&builder, line(key, val))
+		decls = append(decls, fmt.Sprintf(`$%s := %q`, key, val))
 	}
-	return builder.String()
-}
-
-// tplWrap wraps a string with template delimiters.
-func tplWrap(s string) string {
-	return fmt.Sprintf("%s %s %s", openingDelim, s, closingDelim)
+	return decls
 }
@@ -0,0 +1,109 @@
+package caddyyaml
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// contextLines is the number of lines shown before and after the line of a template error.
+const contextLines = 2
+
+// templateErrorRegexp matches the line, optional column and message following the template name
+// in text/template errors.
+var templateErrorRegexp = regexp.MustCompile(`(?s)^(\d+)(?::(\d+))?: (.*)$`)
+
+// templateError is a template parse or execution error located in the source file.
+type templateError struct {
+	pos Position
+	msg string
+
+	// context holds the source lines around the error
+	context string
+}
+
+func (e *templateError) Error() string {
+	loc := fmt.Sprintf("%s:%d", e.pos.File, e.pos.Line)
+	if e.pos.Column > 0 {
+		loc += fmt.Sprintf(":%d", e.pos.Column)
+	}
+	return fmt.Sprintf("%s: %s\n%s", loc, e.msg, e.context)
+}
+
+// newTemplateError locates an error returned by text/template for the template name in body,
+// which is prefixed with prelude on its first line. Other errors are returned unchanged.
+func newTemplateError(name string, body []byte, prelude string, err error) error {
+	rest, ok := strings.CutPrefix(err.Error(), "template: "+name+":")
+	if !ok {
+		return err
+	}
+	m := templateErrorRegexp.FindStringSubmatch(rest)
+	if m == nil {
+		return err
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	col := 0
+	if m[2] != "" {
+		// Columns are zero-based byte offsets, which include the prelude on the first line
+		col, _ = strconv.Atoi(m[2])
+		if line == 1 {
+			col -= len(prelude)
+		}
+		col = max(col+1, 1)
+	}
+	msg := strings.Replace(m[3], fmt.Sprintf("executing %q ", name), "", 1)
+	// Null values are removed before executing strict templates, so they are reported as missing too
+	msg = strings.Replace(msg, "map has no entry for key", "missing or null value for key", 1)
+
+	return &templateError{
+		pos:     Position{File: name, Line: line, Column: col},
+		msg:     msg,
+		context: sourceContext(body, line, col),
+	}
+}
+
+// templateErrorMessage returns the message of a template error without its location and context.
+func templateErrorMessage(err error) string {
+	var tplErr *templateError
+	if errors.As(err, &tplErr) {
+		return tplErr.msg
+	}
+	return err.Error()
+}
+
+// sourceContext renders the lines of body around line, marking the line and, if known, the column.
+func sourceContext(body []byte, line, col int) string {
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	last := min(line+contextLines, len(lines))
+	width := len(strconv.Itoa(last))
+
+	var b strings.Builder
+	for i := max(line-contextLines, 1); i <= last; i++ {
+		text := lines[i-1]
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %*d | %s\n", marker, width, i, text)
+
+		if i == line && col > 0 {
+			fmt.Fprintf(&b, "  %*s | %s^\n", width, "", caretIndent(text, col))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// caretIndent returns the indentation placing a caret below column col of text.
+// Tabs are kept so the caret lines up with the column.
+func caretIndent(text string, col int) string {
+	indent := []rune(text[:min(col-1, len(text))])
+	for j, r := range indent {
+		if r != '\t' {
+			indent[j] = ' '
+		}
+	}
+	return string(indent)
+}
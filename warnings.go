@@ -18,6 +18,16 @@ func (w *warningsCollector) Add(line int, directive string, message string) {
 	})
 }
 
+// AddFile adds a warning about a file other than the one being adapted, such as an included file.
+func (w *warningsCollector) AddFile(file string, line int, directive string, message string) {
+	w.warnings = append(w.warnings, caddyconfig.Warning{
+		File:      file,
+		Line:      line,
+		Directive: directive,
+		Message:   message,
+	})
+}
+
 // newWarningsCollector creates a new warnings collector for the specified filename.
 func newWarningsCollector(filename string) *warningsCollector {
 	return &warningsCollector{filename, nil}
@@ -18,6 +18,69 @@ type Adapter struct{}
 // the value of `os.Environ()` is used.
 const envOptionName = "yaml.Env"
 
+// IncludeCacheDirOptionName is the name of the option to set the directory remote includes are cached in.
+// If this is not set then remote includes are cached in the Caddy data directory.
+// An empty string disables the cache.
+const IncludeCacheDirOptionName = "yaml.IncludeCacheDir"
+
+// ProvenanceOptionName is the name of the option to set a *Provenance that is filled in with the
+// source file positions of every value in the adapted config.
+const ProvenanceOptionName = "yaml.Provenance"
+
+// Options confining includes. Each can also be set in the top-level adapter section of the
+// root file, e.g. `adapter: {include_root: ./, max_include_depth: 5}`; the options take precedence.
+const (
+	// IncludeRootOptionName is the name of the option to confine includes to a directory.
+	IncludeRootOptionName = "yaml.IncludeRoot"
+	// FollowSymlinksOptionName is the name of the option to allow (default) or reject including symlinks.
+	FollowSymlinksOptionName = "yaml.FollowSymlinks"
+	// MaxIncludeDepthOptionName is the name of the option to limit the nesting of includes.
+	MaxIncludeDepthOptionName = "yaml.MaxIncludeDepth"
+	// MaxIncludeFilesOptionName is the name of the option to limit the number of included files.
+	MaxIncludeFilesOptionName = "yaml.MaxIncludeFiles"
+	// MaxIncludeSizeOptionName is the name of the option to limit the total size in bytes of included files.
+	MaxIncludeSizeOptionName = "yaml.MaxIncludeSize"
+)
+
+// FSOptionName is the name of the option to set an fs.FS that include paths without a scheme,
+// including the filename option, are read from instead of the operating system.
+const FSOptionName = "yaml.FS"
+
+// EnvVariablesOptionName is the name of the option to declare (default) or not declare the environment
+// variables as $VAR template variables. They remain available as .Env and through the env function.
+// It can also be set in the adapter section of the root file, e.g. `adapter: {env_variables: false}`.
+const EnvVariablesOptionName = "yaml.EnvVariables"
+
+// StrictOptionName is the name of the option to fail templates referencing missing or null values,
+// instead of rendering them as "<no value>". It can also be set in the adapter section of the root file,
+// e.g. `adapter: {strict: true}`.
+const StrictOptionName = "yaml.Strict"
+
+// DelimitersOptionName is the name of the option to change the template delimiters of files without
+// a delims header, given as a list of the opening and closing delimiter, e.g. []string{"#{{", "}}"}.
+// It can also be set in the adapter section of the root file, e.g. `adapter: {delimiters: ["#{{", "}}"]}`.
+const DelimitersOptionName = "yaml.Delimiters"
+
+// AllowFuncsOptionName is the name of the option listing the only template functions available,
+// e.g. []string{"upper", "default"}. Other sprig functions fail when called. Without env, templates
+// have no access to the environment: .Env is empty and no $VAR variables are declared.
+// Includes and !file tags can still read any file, such as /proc/self/environ, unless confined
+// with IncludeRootOptionName. It can also be set in the adapter section of the root file as allow_funcs.
+const AllowFuncsOptionName = "yaml.AllowFuncs"
+
+// DenyFuncsOptionName is the name of the option listing template functions that fail when called,
+// e.g. []string{"env", "expandenv"}. It can also be set in the adapter section of the root file as deny_funcs.
+const DenyFuncsOptionName = "yaml.DenyFuncs"
+
+// ReproducibleOptionName is the name of the option to make non-deterministic template functions such as
+// now, randAlpha and uuidv4 fail, so the same inputs always adapt to the same JSON.
+// It can also be set in the adapter section of the root file, e.g. `adapter: {reproducible: true}`.
+const ReproducibleOptionName = "yaml.Reproducible"
+
+// httpClientOptionName is the name of the option to set the HTTP client used to fetch remote includes.
+// This is mainly intended as an internal option to aid in testing.
+const httpClientOptionName = "yaml.HTTPClient"
+
 // Adapt converts the YAML config in body to Caddy JSON.
 func (a Adapter) Adapt(body []byte, options map[string]any) ([]byte, []caddyconfig.Warning, error) {
 	return adapt(body, options)